@@ -1,5 +1,28 @@
 package command
 
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Types for Argument.Type
+const (
+	// ArgumentString accepts any value. This is the default type.
+	ArgumentString = "string"
+	// ArgumentBool accepts the values accepted by strconv.ParseBool
+	// as well as yes and no.
+	ArgumentBool = "bool"
+	// ArgumentInt accepts integers.
+	ArgumentInt = "int"
+	// ArgumentFloat accepts floating point numbers.
+	ArgumentFloat = "float"
+	// ArgumentFile accepts paths to existing files.
+	ArgumentFile = "file"
+)
+
 // Type Argument holds a required argument for a command. Command
 // arguments are processed in the same order they're defined.
 // Optional arguments must always be the last ones.
@@ -11,4 +34,151 @@ type Argument struct {
 	Help string
 	// Wheter the argument is optional
 	Optional bool
+	// Validate, if non-nil, is called with the value provided
+	// for the argument before running the command. If it returns
+	// an error, the command is not run. Note that Validate is not
+	// called for optional arguments which were not provided.
+	Validate func(string) error
+	// Default is the value returned by Args when an optional
+	// argument was not provided. It's ignored if empty.
+	Default string
+	// StdinDash makes a - provided for this argument read its
+	// values from the standard input, one per line, replacing
+	// the - in the arguments received by the command. Note that
+	// Validate is not called in that case.
+	StdinDash bool
+	// Type, if non-empty, restricts the values accepted for the
+	// argument. It must be one of the Argument* constants (e.g.
+	// ArgumentInt), otherwise Validate and New report an error.
+	// Values are checked before calling Validate.
+	Type string
+	// Complete, if non-nil, is called by the shell completion support
+	// (see CompleteCommand) to obtain the candidates for this argument.
+	// It receives the arguments before it and the partial value being
+	// completed, which the returned candidates should start with.
+	Complete func(args *Args, toComplete string) []string
+}
+
+// isArgumentType returns true iff t is a valid Argument.Type.
+func isArgumentType(t string) bool {
+	switch t {
+	case "", ArgumentString, ArgumentBool, ArgumentInt, ArgumentFloat, ArgumentFile:
+		return true
+	}
+	return false
+}
+
+// checkType returns an error if value is not valid for the
+// argument type. Unknown types accept any value, since they're
+// reported by Cmd.checkArgs.
+func (a *Argument) checkType(value string) error {
+	var err error
+	switch a.Type {
+	case ArgumentBool:
+		_, err = parseBool(value)
+	case ArgumentInt:
+		_, err = strconv.Atoi(value)
+	case ArgumentFloat:
+		_, err = strconv.ParseFloat(value, 64)
+	case ArgumentFile:
+		_, err = os.Stat(value)
+	}
+	if ne, ok := err.(*strconv.NumError); ok {
+		return fmt.Errorf("%s is not a valid %s: %v", value, a.Type, ne.Err)
+	}
+	return err
+}
+
+// parseBool works like strconv.ParseBool, but it
+// also accepts yes and no.
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, errors.New("must be one of true, false, 1, 0, yes or no")
+	}
+	return b, nil
+}
+
+// checkArgs checks that the arguments declared by the command are
+// well formed, independently of the ones provided by the user. It
+// returns an error if a required argument comes after an optional one
+// or if an argument has an unknown type.
+func (c *Cmd) checkArgs() error {
+	hasOptional := false
+	for _, v := range c.Args {
+		if v == nil {
+			continue
+		}
+		if !isArgumentType(v.Type) {
+			return fmt.Errorf("argument %q has unknown type %q", v.Name, v.Type)
+		}
+		if v.Optional {
+			hasOptional = true
+			continue
+		}
+		if hasOptional {
+			return fmt.Errorf("required argument %q comes after optional arguments", v.Name)
+		}
+	}
+	return nil
+}
+
+// checkArgCount panics if n, the number of arguments passed
+// to the function named fn, is negative.
+func checkArgCount(fn string, n int) {
+	if n < 0 {
+		panic(fmt.Errorf("%s: invalid negative number of arguments %d", fn, n))
+	}
+}
+
+func countedArguments(required int, optional int) []*Argument {
+	args := make([]*Argument, 0, required+optional)
+	for ii := 0; ii < required+optional; ii++ {
+		args = append(args, &Argument{
+			Name:     fmt.Sprintf("arg%d", ii+1),
+			Optional: ii >= required,
+		})
+	}
+	return args
+}
+
+// MinArgs returns the arguments for a command which
+// requires at least n arguments, with no upper limit.
+// It panics if n is negative.
+func MinArgs(n int) []*Argument {
+	checkArgCount("MinArgs", n)
+	return countedArguments(n, 0)
+}
+
+// MaxArgs returns the arguments for a command which
+// accepts at most n arguments. It panics if n is negative.
+func MaxArgs(n int) []*Argument {
+	checkArgCount("MaxArgs", n)
+	return append(countedArguments(0, n), nil)
+}
+
+// RangeArgs returns the arguments for a command which
+// requires at least min arguments and accepts at most
+// max arguments. It panics if any of them is negative
+// or if min is greater than max.
+func RangeArgs(min int, max int) []*Argument {
+	checkArgCount("RangeArgs", min)
+	checkArgCount("RangeArgs", max)
+	if min > max {
+		panic(fmt.Errorf("RangeArgs: minimum number of arguments %d is greater than the maximum %d", min, max))
+	}
+	return append(countedArguments(min, max-min), nil)
+}
+
+// ExactArgs returns the arguments for a command which
+// requires exactly n arguments. It panics if n is negative.
+func ExactArgs(n int) []*Argument {
+	checkArgCount("ExactArgs", n)
+	return append(countedArguments(n, 0), nil)
 }
@@ -1,23 +1,33 @@
 package command
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"os"
 	"reflect"
 	"strconv"
+	"strings"
 )
 
 var (
 	// NoArgs is used to indicate that a command
 	// receives no arguments. If the user provides any
 	// additional arguments, it will return an error.
+	//
+	// More generally, a nil *Argument at the end of the
+	// arguments indicates that the command doesn't accept
+	// any arguments besides the declared ones. See also
+	// ExactArgs, MaxArgs and RangeArgs.
 	NoArgs = []*Argument{nil}
 )
 
 // Type Args is used by command functions to
 // receive their arguments.
 type Args struct {
-	args []string
-	cmd  *Cmd
+	args        []string
+	cmd         *Cmd
+	passThrough []string
 }
 
 func newArgs(values []string, cmd *Cmd) *Args {
@@ -27,72 +37,355 @@ func newArgs(values []string, cmd *Cmd) *Args {
 	}
 }
 
+// passThroughArgs returns the arguments found after the "--"
+// separator, given the arguments remaining after parsing the
+// command flags and whether the flag package consumed the
+// separator while parsing them (see consumesSeparator).
+func passThroughArgs(rest []string, consumed bool) []string {
+	if consumed {
+		return rest
+	}
+	for ii, v := range rest {
+		if v == "--" {
+			return rest[ii+1:]
+		}
+	}
+	return nil
+}
+
 func (a *Args) argumentPos(name string) (int, error) {
 	for ii, v := range a.cmd.Args {
-		if v.Name == name {
+		if v != nil && v.Name == name {
 			return ii, nil
 		}
 	}
 	return 0, fmt.Errorf("argument %q not found", name)
 }
 
+// maxArgs returns the maximum number of arguments accepted
+// by the command and true, or false if there's no limit.
+func (a *Args) maxArgs() (int, bool) {
+	args := a.cmd.Args
+	if len(args) == 0 || args[len(args)-1] != nil {
+		return 0, false
+	}
+	max := 0
+	for _, v := range args {
+		if v != nil {
+			max++
+		}
+	}
+	return max, true
+}
+
+// declared returns the number of arguments declared
+// by the command, ignoring any nil *Argument.
+func (a *Args) declared() int {
+	declared := 0
+	for _, v := range a.cmd.Args {
+		if v != nil {
+			declared++
+		}
+	}
+	return declared
+}
+
 func (a *Args) validate() error {
 	if reflect.DeepEqual(a.cmd.Args, NoArgs) && len(a.args) > 0 {
 		return ErrUnusedArguments
 	}
-	hasOptional := false
+	if err := a.cmd.checkArgs(); err != nil {
+		return err
+	}
 	prov := len(a.args)
 	var req int
 	for _, v := range a.cmd.Args {
-		if v.Optional {
-			hasOptional = true
-			continue
-		}
-		if hasOptional {
-			return fmt.Errorf("required argument %q comes after optional arguments", v.Name)
+		if v != nil && !v.Optional {
+			req++
 		}
-		req++
 	}
 	if req > prov {
 		return fmt.Errorf("%d arguments required, but only %d provided", req, prov)
 	}
+	if max, limited := a.maxArgs(); limited && prov > max {
+		if max == req {
+			return fmt.Errorf("expects exactly %d arguments, got %d", max, prov)
+		}
+		return fmt.Errorf("expects at most %d arguments, got %d", max, prov)
+	}
+	if a.cmd.StrictArgs {
+		if declared := a.declared(); prov > declared {
+			return fmt.Errorf("too many arguments: expected at most %d, got %d", declared, prov)
+		}
+	}
+	for ii, v := range a.cmd.Args {
+		if v == nil || ii >= prov || a.isStdinDash(ii) {
+			continue
+		}
+		if err := v.checkType(a.args[ii]); err != nil {
+			return fmt.Errorf("invalid value for argument %s: %v", v.Name, err)
+		}
+		if v.Validate == nil {
+			continue
+		}
+		if err := v.Validate(a.args[ii]); err != nil {
+			return fmt.Errorf("invalid value for argument %s: %v", v.Name, err)
+		}
+	}
+	return nil
+}
+
+func (a *Args) isStdinDash(pos int) bool {
+	return pos < len(a.cmd.Args) && a.cmd.Args[pos] != nil && a.cmd.Args[pos].StdinDash &&
+		pos < len(a.args) && a.args[pos] == "-"
+}
+
+// expandStdin replaces the first argument provided as - for
+// an Argument with StdinDash with the values read from stdin.
+func (a *Args) expandStdin(r io.Reader) error {
+	for ii := range a.args {
+		if a.isStdinDash(ii) {
+			values, err := readLines(r)
+			if err != nil {
+				return err
+			}
+			a.args = append(append(a.args[:ii:ii], values...), a.args[ii+1:]...)
+			return nil
+		}
+	}
 	return nil
 }
 
+func readLines(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading arguments from stdin: %v", err)
+	}
+	return lines, nil
+}
+
+// Returns the non-empty lines read from the standard
+// input, with leading and trailing whitespace removed.
+// Handlers might use it to read their arguments from
+// stdin. See also Argument.StdinDash.
+func (a *Args) ReadStdin() ([]string, error) {
+	return readLines(os.Stdin)
+}
+
 // Returns the argument with the given name as an int.
 // If the argument does not exist or it can't be parsed
 // as an int, it panics.
 func (a *Args) Int(name string) int {
-	s := a.String(name)
-	val, err := strconv.Atoi(s)
+	val, err := a.IntE(name)
 	if err != nil {
-		panic(fmt.Errorf("error parsing int argument %s: %v", name, err))
+		panic(err)
 	}
 	return val
 }
 
+// Returns the argument with the given name as an int.
+// If the argument does not exist or it can't be parsed
+// as an int, it returns an error.
+func (a *Args) IntE(name string) (int, error) {
+	s, err := a.stringE(name)
+	if err != nil {
+		return 0, err
+	}
+	val, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing int argument %s %q: %v", name, s, numError(err))
+	}
+	return val, nil
+}
+
+// Returns the argument with the given name as a float64.
+// If the argument does not exist or it can't be parsed
+// as a float64, it returns an error.
+func (a *Args) FloatE(name string) (float64, error) {
+	s, err := a.stringE(name)
+	if err != nil {
+		return 0, err
+	}
+	val, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing float argument %s %q: %v", name, s, numError(err))
+	}
+	return val, nil
+}
+
+// Returns the argument with the given name as a bool.
+// Accepted values are the same ones accepted by
+// strconv.ParseBool, plus yes and no. If the argument
+// does not exist or it can't be parsed as a bool, it
+// returns an error.
+func (a *Args) BoolE(name string) (bool, error) {
+	s, err := a.stringE(name)
+	if err != nil {
+		return false, err
+	}
+	val, err := parseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("error parsing bool argument %s %q: %v", name, s, err)
+	}
+	return val, nil
+}
+
+func (a *Args) stringE(name string) (string, error) {
+	p, err := a.argumentPos(name)
+	if err != nil {
+		return "", err
+	}
+	return a.StringAt(p), nil
+}
+
 // Returns the argument with the given name as a string.
 // If the argument does not exist, it panics.
 func (a *Args) String(name string) string {
-	p, err := a.argumentPos(name)
+	s, err := a.stringE(name)
 	if err != nil {
 		panic(err)
 	}
-	return a.StringAt(p)
+	return s
 }
 
 // Returns the argument at the given position as a string.
-// If the argument was not provided, it returns an empty
-// string.
+// If the argument was not provided, it returns its default
+// value (see Argument.Default), which might be empty.
 func (a *Args) StringAt(pos int) string {
 	if pos >= len(a.args) {
+		if pos >= 0 && pos < len(a.cmd.Args) && a.cmd.Args[pos] != nil {
+			return a.cmd.Args[pos].Default
+		}
 		return ""
 	}
 	return a.args[pos]
 }
 
+// Returns the argument with the given name, which must be
+// the path to an existing file. If the argument does not
+// exist or the file does not exist, it returns an error.
+func (a *Args) File(name string) (string, error) {
+	p, err := a.argumentPos(name)
+	if err != nil {
+		return "", err
+	}
+	return a.FileAt(p)
+}
+
+// Returns the argument with the given name, which must be
+// the path to an existing file. If the argument does not
+// exist or the file does not exist, it panics.
+func (a *Args) MustFile(name string) string {
+	path, err := a.File(name)
+	if err != nil {
+		panic(err)
+	}
+	return path
+}
+
+// Returns the argument at the given position, which must
+// be the path to an existing file. If the file does not
+// exist, it returns an error.
+func (a *Args) FileAt(pos int) (string, error) {
+	path := a.StringAt(pos)
+	name := fmt.Sprintf("at position %d", pos)
+	if pos >= 0 && pos < len(a.cmd.Args) && a.cmd.Args[pos] != nil {
+		name = a.cmd.Args[pos].Name
+	}
+	if path == "" {
+		return "", fmt.Errorf("argument %s: no file provided", name)
+	}
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("argument %s: file %s does not exist", name, path)
+		}
+		return "", fmt.Errorf("argument %s: %v", name, err)
+	}
+	return path, nil
+}
+
+// Returns the number of arguments provided.
+func (a *Args) Len() int {
+	return len(a.args)
+}
+
+// Returns wheter the argument with the given name was
+// provided. If there's no argument with the given name,
+// it returns false.
+func (a *Args) Has(name string) bool {
+	p, err := a.argumentPos(name)
+	return err == nil && p < len(a.args)
+}
+
 // Returns the arguments as they were specified in the
 // command line.
 func (a *Args) Args() []string {
 	return a.args
 }
+
+// Returns the arguments as they were specified in the
+// command line. It's equivalent to Args.
+func (a *Args) Values() []string {
+	return a.args
+}
+
+// Returns the arguments in the [from, to) range. Both
+// bounds are clamped to the available arguments, so it
+// never panics.
+func (a *Args) Slice(from, to int) []string {
+	if from < 0 {
+		from = 0
+	}
+	if to > len(a.args) {
+		to = len(a.args)
+	}
+	if from >= to {
+		return nil
+	}
+	return a.args[from:to]
+}
+
+// Returns the arguments provided beyond the ones declared
+// in Cmd.Args (e.g. for a command declaring 2 arguments
+// which receives 5 of them, it returns the last 3). If
+// there are no additional arguments, it returns nil.
+func (a *Args) Remaining() []string {
+	return a.Slice(a.declared(), len(a.args))
+}
+
+// Calls fn for every argument, in order, with its position
+// and its value.
+func (a *Args) Each(fn func(i int, value string)) {
+	for ii, v := range a.args {
+		fn(ii, v)
+	}
+}
+
+// Returns a map with the names of the declared arguments
+// as keys and their values as returned by String, so
+// arguments not provided map to their default value (or
+// to an empty string). Arguments provided beyond the
+// declared ones are not included.
+func (a *Args) Map() map[string]string {
+	m := make(map[string]string, len(a.cmd.Args))
+	for ii, v := range a.cmd.Args {
+		if v != nil {
+			m[v.Name] = a.StringAt(ii)
+		}
+	}
+	return m
+}
+
+// Returns the arguments provided after the "--" separator,
+// without any processing. Note that these arguments are also
+// included in the ones returned by Args, since they're
+// positional arguments too.
+func (a *Args) PassThrough() []string {
+	return a.passThrough
+}
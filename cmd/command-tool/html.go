@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+
+	"gopkgs.com/command.v1"
+)
+
+var (
+	htmlTmpl = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Help.Name }}</title>
+</head>
+<body>
+{{ if .Header }}{{ .Header }}{{ else }}<h1>{{ .Help.Name }}</h1>{{ end }}
+{{ with .Help.Flags }}<h2 id="global-flags">Global flags</h2>
+{{ template "flags" . }}
+{{ end }}{{ with .Help.Commands }}<h2 id="commands">Commands</h2>
+<ul>
+{{ range . }}<li><a href="#command-{{ .Name }}">{{ .Name }}</a>{{ with .Help }}: {{ . }}{{ end }}</li>
+{{ end }}</ul>
+{{ range . }}<h3 id="command-{{ .Name }}">{{ .Name }}</h3>
+{{ with .Help }}<p>{{ . }}</p>
+{{ end }}{{ if .Usage }}<p>Usage: <code>{{ $.Help.Name }} {{ .Name }} {{ .Usage }}</code></p>
+{{ end }}{{ with .LongHelp }}<pre>{{ . }}</pre>
+{{ end }}{{ with .Flags }}<h4>Flags</h4>
+{{ template "flags" . }}
+{{ end }}{{ with .Examples }}<h4>Examples</h4>
+{{ range . }}{{ with .Description }}<p>{{ . }}</p>
+{{ end }}<pre><code>{{ .Command }}</code></pre>
+{{ end }}{{ end }}{{ end }}{{ end }}{{ .Footer }}
+</body>
+</html>
+{{ define "flags" }}<dl>
+{{ range . }}<dt><code>-{{ .Name }}</code>{{ with .Type }} <em>({{ . }})</em>{{ end }}</dt>
+<dd>{{ .Help }}{{ with .Default }} <em>default: {{ . }}</em>{{ end }}</dd>
+{{ end }}</dl>{{ end }}`
+
+	htmlTemplate = template.Must(template.New("html").Parse(htmlTmpl))
+)
+
+type htmlDocument struct {
+	Help   *command.Help
+	Header template.HTML
+	Footer template.HTML
+}
+
+func helpHTMLCommand(args *command.Args, opts *helpOptions) error {
+	help, err := toolHelp(args, opts)
+	if err != nil {
+		return err
+	}
+	doc := &htmlDocument{Help: help}
+	if opts.Header != "" {
+		data, err := ioutil.ReadFile(opts.Header)
+		if err != nil {
+			return fmt.Errorf("error reading header file %s: %s", opts.Header, err)
+		}
+		doc.Header = template.HTML(data)
+	}
+	if opts.Footer != "" {
+		data, err := ioutil.ReadFile(opts.Footer)
+		if err != nil {
+			return fmt.Errorf("error reading footer file %s: %s", opts.Footer, err)
+		}
+		doc.Footer = template.HTML(data)
+	}
+	var out bytes.Buffer
+	if err := htmlTemplate.Execute(&out, doc); err != nil {
+		return fmt.Errorf("error executing template: %s", err)
+	}
+	return writeOutput(opts, &out)
+}
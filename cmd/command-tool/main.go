@@ -46,6 +46,10 @@ var (
 
 {{ range .Flags }}    {{ template "flag" . }}
 {{ end }}
+{{ end }}{{ if .Examples }}    Examples:
+
+{{ range .Examples }}     - {{ with .Description }}{{ .|e }}: {{ end }}` + "```" + `{{ .Command }}` + "```" + `
+{{ end }}
 {{ end }}
 {{ end }}
 {{ end }}
@@ -61,6 +65,13 @@ var (
 			Func:    helpCommand,
 			Options: &helpOptions{},
 		},
+		{
+			Name:    "help-html",
+			Usage:   "<cmd>",
+			Help:    "Generates an HTML document with the help for the given command",
+			Func:    helpHTMLCommand,
+			Options: &helpOptions{},
+		},
 	}
 
 	templateFuncs = template.FuncMap{
@@ -132,24 +143,52 @@ type helpOptions struct {
 	Header string `help:"Header to prepend to the document"`
 	Footer string `help:"Footer to append to the document"`
 	Output string `name:"o" help:"Output file. If empty, output is printed to stdout"`
+	Env    string `help:"Environment variable which makes the tool dump its help, for tools setting Options.DumpHelpEnvVar"`
 }
 
-func helpCommand(args []string, opts *helpOptions) error {
-	if len(args) != 1 {
-		return fmt.Errorf("help only accepts one argument")
+// toolHelp runs the tool given in args with CommandDumpHelpEnvVar
+// (or the variable in opts.Env) set and returns its help.
+func toolHelp(args *command.Args, opts *helpOptions) (*command.Help, error) {
+	if args.Len() != 1 {
+		return nil, fmt.Errorf("help only accepts one argument")
 	}
-	cmd := exec.Command(args[0])
+	env := command.CommandDumpHelpEnvVar
+	if opts.Env != "" {
+		env = opts.Env
+	}
+	cmd := exec.Command(args.StringAt(0))
 	cmd.Env = []string{
-		command.CommandDumpHelpEnvVar + "=1",
+		env + "=1",
 	}
 	var buf bytes.Buffer
 	cmd.Stdout = &buf
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
-		return err
+		return nil, err
 	}
 	var help *command.Help
 	if err := json.Unmarshal(buf.Bytes(), &help); err != nil {
+		return nil, err
+	}
+	return help, nil
+}
+
+// writeOutput writes the generated document to the
+// output file in opts or to stdout if there's none.
+func writeOutput(opts *helpOptions, out *bytes.Buffer) error {
+	if opts.Output != "" {
+		if err := ioutil.WriteFile(opts.Output, out.Bytes(), 0644); err != nil {
+			return fmt.Errorf("error writing outfile file %s: %s", opts.Output, err)
+		}
+	} else {
+		fmt.Print(out.String())
+	}
+	return nil
+}
+
+func helpCommand(args *command.Args, opts *helpOptions) error {
+	help, err := toolHelp(args, opts)
+	if err != nil {
 		return err
 	}
 	var out bytes.Buffer
@@ -173,14 +212,7 @@ func helpCommand(args []string, opts *helpOptions) error {
 			return fmt.Errorf("error reading header file %s: %s", opts.Footer, err)
 		}
 	}
-	if opts.Output != "" {
-		if err := ioutil.WriteFile(opts.Output, out.Bytes(), 0644); err != nil {
-			return fmt.Errorf("error writing outfile file %s: %s", opts.Output, err)
-		}
-	} else {
-		fmt.Print(out.String())
-	}
-	return nil
+	return writeOutput(opts, &out)
 }
 
 func main() {
@@ -38,32 +38,58 @@
 package command
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"sort"
+	"strings"
 	"text/tabwriter"
+	"text/template"
+	"unicode/utf8"
 )
 
 var (
-	argsType = reflect.TypeOf([]string(nil))
-	errType  = reflect.TypeOf((*error)(nil)).Elem()
-	cmdType  = reflect.TypeOf((*Cmd)(nil))
+	argsType    = reflect.TypeOf([]string(nil))
+	errType     = reflect.TypeOf((*error)(nil)).Elem()
+	cmdType     = reflect.TypeOf((*Cmd)(nil))
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
 	// ErrNoCommand is returned from Run when no command
 	// has been specified (i.e. there are no arguments).
 	ErrNoCommand = errors.New("no command provided")
 	// ErrHelp is returned from Run when the help is shown,
 	// either the brief help or the detailed help for a
-	// command.
+	// command. Command handlers might also return ErrHelp
+	// to print the detailed help for their command.
 	ErrHelp = errors.New("help has been shown")
 	// ErrUnusedArguments is returned form Run when the command
 	// does not accept any arguments, but the user has provided
 	// some.
 	ErrUnusedArguments = errors.New("arguments provided but not used")
+	// ErrInvalidOptions is wrapped by *InvalidOptionsError.
+	ErrInvalidOptions = errors.New("invalid options")
+	// ErrUnknownFlag is returned from Run when the user provides
+	// a global flag which has not been defined or a flag to a
+	// command with Cmd.StrictFlags.
+	ErrUnknownFlag = errors.New("unknown flag provided")
+)
+
+const (
+	// ErrorFormatText prints errors returned by commands as
+	// human readable text.
+	ErrorFormatText = "text"
+	// ErrorFormatJSON prints errors returned by commands as a
+	// JSON object with the keys "error", "command" and "code",
+	// where code is the exit status that Exit would use.
+	ErrorFormatJSON = "json"
 )
 
 // UnknownCommandError is returned from Run when the specified
@@ -74,6 +100,70 @@ func (e UnknownCommandError) Error() string {
 	return fmt.Sprintf("unknown command %s", string(e))
 }
 
+// InvalidOptionsError is returned by Validate when the options for
+// a command or the global options are not a pointer to a struct. Note
+// that running a command with invalid options panics with this error.
+type InvalidOptionsError struct {
+	// Command is the name of the command, empty for
+	// the global options.
+	Command string
+	// Type is the type of the invalid options.
+	Type reflect.Type
+}
+
+func (e *InvalidOptionsError) Error() string {
+	if e.Type.Kind() != reflect.Ptr {
+		if e.Command != "" {
+			return fmt.Sprintf("invalid command %s options %s, must be a pointer", e.Command, e.Type)
+		}
+		return fmt.Sprintf("invalid options %s, must be a pointer", e.Type)
+	}
+	if e.Command != "" {
+		return fmt.Sprintf("command %s options field is not a struct, it's %s", e.Command, e.Type)
+	}
+	return fmt.Sprintf("options field is not a struct, it's %s", e.Type)
+}
+
+// Unwrap returns ErrInvalidOptions, so errors.Is(err, ErrInvalidOptions)
+// might be used to check for invalid options.
+func (e *InvalidOptionsError) Unwrap() error {
+	return ErrInvalidOptions
+}
+
+// CommandError is returned from Run when Options.WrapErrors is
+// enabled and a command fails, wrapping the error returned by its
+// handler (or by Cmd.BeforeFunc or Cmd.AfterFunc).
+type CommandError struct {
+	// Name is the name of the command
+	Name string
+	// Err is the error returned by the command
+	Err error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("command %s: %v", e.Name, e.Err)
+}
+
+// Unwrap returns the error returned by the command, so errors.Is
+// and errors.As might be used to inspect it.
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// AmbiguousCommandError is returned from Run when Options.MatchPrefixes
+// is enabled and the specified command is a prefix of several commands.
+type AmbiguousCommandError struct {
+	// Name is the command name provided by the user
+	Name string
+	// Candidates contains the names of all the commands
+	// starting with Name.
+	Candidates []string
+}
+
+func (e *AmbiguousCommandError) Error() string {
+	return fmt.Sprintf("ambiguous command %s, could be any of: %s", e.Name, strings.Join(e.Candidates, ", "))
+}
+
 // Cmd represents an available command.
 type Cmd struct {
 	// Name is the name of the command, case sensitive.
@@ -86,22 +176,51 @@ type Cmd struct {
 	// command (e.g. myprogram help somecommand)
 	LongHelp string
 	// Usage is displayed when showing the help for a specific
-	// command. The program name (os.Args[0]) and the command
+	// command. The program name (Options.Name) and the command
 	// name are prepended to it when displaying it to the user,
 	// as well as any arguments defined in the Args field.
 	// (e.g. Usage = <something> shows "usage: myprog subcmd <something>")
 	Usage string
+	// Category is used to group commands when listing them. Commands
+	// with the same category are listed together under a heading with
+	// its name, in the same order categories first appear. Commands
+	// without a category are listed first.
+	Category string
 	// Args accepted by the command. If nil, no argument validation
 	// is performed. To define a command which accepts no arguments and
-	// errors when arguments are passed, set this field to NoArgs.
+	// errors when arguments are passed, set this field to NoArgs. To
+	// only validate the number of arguments, use MinArgs, MaxArgs,
+	// RangeArgs or ExactArgs.
 	// See the Argument and Args types for more information.
 	Args []*Argument
+	// SharedOptions contains additional options for the command, with the
+	// same requirements as Options. Their flags are merged with the ones
+	// generated from Options, so flag names must be unique across all of
+	// them. Since they're not passed to Func, they're intended for sets
+	// of flags shared by several commands (e.g. authentication flags),
+	// which read the parsed values from the same pointers stored here.
+	SharedOptions []interface{}
+	// Examples, if non-empty, are shown in the command help under
+	// an "Examples" heading, in the same order they're declared.
+	Examples []*Example
 	// Func is the handler function for the command. The function must take either
 	// one or two arguments. The first one must be an *Args, which is
-	// used to access non-flag arguments.
+	// used to access non-flag arguments. Optionally, the function might
+	// also take a context.Context before the *Args argument, which receives
+	// the context passed to RunContext (or context.Background() when using
+	// Run or RunOpts).
 	// If the function accepts a second argument it must
-	// be of the exact same type than the value provided in the Options field.
-	// Handler functions might optionally return an error value.
+	// be of the exact same type than the value provided in the Options field,
+	// or of the type it points to, in order to receive a copy of the options.
+	// Finally, the function might take an additional argument (after the
+	// command options, if any) to receive the global options, which must
+	// match the type of Options.Options or the type it points to.
+	// Handler functions might optionally return an error value, optionally
+	// preceded by a value of any type, which is ignored by RunOpts but
+	// returned in Result.Value by RunResult.
+	//
+	// If Func is nil, running the command just prints its help and
+	// returns ErrHelp.
 	Func interface{}
 	// Options might be either nil or a pointer to a struct type. Command flags
 	// will be generated from this struct, in the same order as the fields are
@@ -110,30 +229,151 @@ type Cmd struct {
 	//
 	//  - name: The name of the flag. If not present, it will default to the field name in lowercase.
 	//  - help: The short help shown the flag package for the given field.
+	//  - group: The name of the group the flag belongs to. Groups are displayed
+	//    under their own heading in the help.
+	//  - layout: The layout used for parsing time.Time fields. If not present,
+	//    it defaults to time.RFC3339.
+	//  - choices: A comma separated list of the values accepted by a string field.
+	//    Any other value is rejected when parsing the flags.
+	//  - env: The name of the environment variable used for setting the flag
+	//    when Options.Env is enabled.
+	//  - placeholder: The name used for the flag value in the help (e.g. FILE
+	//    shows -o FILE rather than -o string).
+	//  - hidden: When set to true, the flag is omitted from the help, but it's
+	//    still accepted. See also Options.ShowHiddenFlags.
+	//
+	// Fields of type map[string]string accept the flag multiple times, each
+	// one with a key=value pair which is added to the map.
+	//
+	// Embedded structs (or pointers to structs) have their fields flattened
+	// into the flags of the parent struct, which allows sharing options
+	// between commands. Flag names must be unique across all the fields.
+	//
+	// Every bool field also gets a negated flag, prefixed by "no-", which sets
+	// the field to false (e.g. -no-cache for a field named Cache). When both
+	// flags are provided, the last one wins. Negated flags are not listed in
+	// the help and they're not registered when another field already uses
+	// the same name.
+	//
+	// Fields might also be pointers to basic types (e.g. *int). These fields
+	// are only set when the flag is provided, so a nil pointer indicates the
+	// flag was omitted.
+	//
+	// Fields of type *url.URL accept only absolute URLs with a host (e.g.
+	// https://example.com/api), rejecting any other values.
 	Options interface{}
+	// BeforeFunc, if non-nil, is called after the flags and the arguments
+	// have been parsed and validated, right before calling Func. If it
+	// returns an error, Func is not called and the error is returned.
+	BeforeFunc func(*Args) error
+	// AfterFunc, if non-nil, is called after Func with the error returned
+	// by it (if any). The error returned by AfterFunc replaces the one
+	// returned by Func, so it might be used to wrap or discard it.
+	AfterFunc func(*Args, error) error
+	// StrictFlags, when Options is nil, makes the command reject any
+	// argument starting with - (other than - itself) which appears
+	// before --, printing an error and returning ErrUnknownFlag.
+	// Otherwise, those arguments are treated as positional ones.
+	StrictFlags bool
+	// StrictArgs makes the command reject more positional arguments
+	// than the ones declared in Args. By default, additional arguments
+	// are accepted unless Args ends with a nil *Argument (see NoArgs).
+	StrictArgs bool
+	// PassThrough indicates that the command forwards the arguments
+	// after -- (see Args.PassThrough) somewhere else, like another
+	// process. When true, the usage line in the command help ends
+	// with [-- args...], explaining the meaning of --.
+	PassThrough bool
+	// RawArgs, when Options is nil, makes the command receive all its
+	// arguments untouched: -h and --help are not handled as a request
+	// for the command help and StrictFlags is ignored. It's intended
+	// for commands which forward their arguments to another program
+	// (e.g. the ones provided by Plugins).
+	RawArgs bool
+	// SkipGlobalHooks makes RunOpts skip Options.BeforeFunc,
+	// Options.Func and Options.FlagsFunc when running this
+	// command, which is useful for commands which don't need
+	// any expensive global setup (e.g. printing the version).
+	// Since Options.BeforeFunc runs before the additional
+	// commands from a CommandProvider or a CommandResolver are
+	// obtained and before Options.RewriteArgs is called, it's
+	// only skipped for commands passed to RunOpts and selected
+	// by the arguments as provided. Additional commands setting
+	// this field only skip Options.Func and Options.FlagsFunc.
+	SkipGlobalHooks bool
+
+	// globalOptions is only used by New, to check the handler
+	// signature (see WithGlobalOptions).
+	globalOptions interface{}
+}
+
+// options returns the non-nil options for the command,
+// including the shared ones.
+func (c *Cmd) options() []interface{} {
+	var options []interface{}
+	if c.Options != nil {
+		options = append(options, c.Options)
+	}
+	return append(options, c.SharedOptions...)
 }
 
 func (c *Cmd) hasArgs() bool {
 	return len(c.Args) > 0 && !reflect.DeepEqual(c.Args, NoArgs)
 }
 
+// Exit statuses used by Exit and StatusFor.
+const (
+	// ExitOK is used when err is nil.
+	ExitOK = 0
+	// ExitError is used for any errors without a more
+	// specific exit status.
+	ExitError = 1
+	// ExitHelp is used for ErrHelp.
+	ExitHelp = 2
+	// ExitNoCommand is used for ErrNoCommand.
+	ExitNoCommand = 3
+	// ExitUnusedArguments is used for ErrUnusedArguments.
+	ExitUnusedArguments = 4
+	// ExitUnknownFlag is used for ErrUnknownFlag.
+	ExitUnknownFlag = 5
+)
+
 // Exit exits with exit status zero when err is nil and with
-// non-zero when err is non-nil.
+// non-zero when err is non-nil. See StatusFor for the exit
+// status used for each error.
 func Exit(err error) {
-	status := 0
+	os.Exit(StatusFor(err))
+}
+
+// StatusFor returns the exit status that Exit uses for the given
+// error, without exiting. When err is a *SignalError, the exit status
+// is 128 plus the signal number. Otherwise, it's one of the Exit*
+// constants. A *CommandError uses the exit status for its Err, while
+// an *exec.ExitError (e.g. from a plugin, see Plugins) uses the exit
+// status of the process.
+func StatusFor(err error) int {
+	if ce, ok := err.(*CommandError); ok {
+		err = ce.Err
+	}
+	if se, ok := err.(*SignalError); ok {
+		return se.status()
+	}
+	if ee, ok := err.(*exec.ExitError); ok && ee.ExitCode() > 0 {
+		return ee.ExitCode()
+	}
 	switch err {
+	case nil:
+		return ExitOK
 	case ErrHelp:
-		status = 2
+		return ExitHelp
 	case ErrNoCommand:
-		status = 3
+		return ExitNoCommand
 	case ErrUnusedArguments:
-		status = 4
-	case nil:
-		// keep 0 status
-	default:
-		status = 1
+		return ExitUnusedArguments
+	case ErrUnknownFlag:
+		return ExitUnknownFlag
 	}
-	os.Exit(status)
+	return ExitError
 }
 
 // Run is a shorthand for RunOpts(nil, nil, commands).
@@ -141,14 +381,59 @@ func Run(commands []*Cmd) error {
 	return RunOpts(nil, nil, commands)
 }
 
+// The Defaulter interface might be implemented by the types used
+// for global or command options in order to set defaults which can't
+// be expressed as constant values (e.g. the current directory).
+// SetDefaults is called before the flags are set up, so the values it
+// sets become the flag defaults and they're shown in the help. Note
+// that SetDefaults might be called multiple times.
+type Defaulter interface {
+	SetDefaults()
+}
+
+func setDefaults(options interface{}) {
+	if d, ok := options.(Defaulter); ok {
+		d.SetDefaults()
+	}
+}
+
 // The CommandProvider interface might be implemented by the
 // type used in the Options field of the Options type. If
 // implemented, its Commands function is called after
-// Options.BeforeFunc and before Options.Func
+// Options.BeforeFunc and before Options.Func, so setting
+// Cmd.SkipGlobalHooks in the returned commands only skips
+// the latter.
 type CommandProvider interface {
 	Commands() ([]*Cmd, error)
 }
 
+// The CommandResolver interface might be implemented by the type
+// used in the Options field of the Options type, in order to provide
+// additional commands lazily. In contrast with CommandProvider,
+// ResolveCommand is only called for the command selected by the
+// user, while CommandNames is only called when the list of commands
+// is displayed. This avoids the cost of building every command when
+// there are lots of them.
+type CommandResolver interface {
+	// ResolveCommand returns the command with the given name. If
+	// there's no such command, it must return nil, nil.
+	ResolveCommand(name string) (*Cmd, error)
+	// CommandNames returns the names of all the commands which can
+	// be resolved.
+	CommandNames() []string
+}
+
+// The CommandHelper interface might be implemented by a CommandResolver
+// in order to show the help of its commands in the list of commands,
+// since they're not resolved to list them. CommandHelp is only called
+// when the list is displayed or when the detailed help for a resolved
+// command without Cmd.Help is requested.
+type CommandHelper interface {
+	// CommandHelp returns the short help for the command with the
+	// given name (see Cmd.Help).
+	CommandHelp(name string) string
+}
+
 // Options are used to specify additional options when calling RunOpts
 type Options struct {
 	// Options represents global options which the application
@@ -158,17 +443,410 @@ type Options struct {
 	//
 	// Optionally, the value in this field might implement the
 	// CommandProvider interface. In that case, its Commands function
-	// is called after BeforeFunc and before Func.
+	// is called after BeforeFunc and before Func. It might also
+	// implement CommandResolver, to provide additional commands
+	// lazily.
 	Options interface{}
 	// Func is called after the command to execute is determined but before
 	// executing it.
 	Func func(*Cmd, *Options) error
+	// FlagsFunc works like Func, but it also receives the values of the
+	// command flags, after parsing them, so code which doesn't know the
+	// types of the command options can inspect them (e.g. checking if
+	// a -dry-run flag is set in any command). Global flags are not
+	// included. It's called right after Func.
+	FlagsFunc func(*Cmd, *Options, *FlagValues) error
 	// BeforeFunc must follow the same characteristics of Func, except it
 	// can't take an optional *Cmd parameter.
 	//
 	// BeforeFunc is called before the command to execute is determined, so
 	// it can be used to conditionally set up additional commands.
 	BeforeFunc func(*Options) error
+	// MatchPrefixes enables matching commands by an unambiguous
+	// prefix of their name (e.g. "stat" would run "status", as long
+	// as there are no other commands starting with "stat"). Exact
+	// matches always take precedence over prefixes.
+	MatchPrefixes bool
+	// Output is used to print any help or error messages. If
+	// nil, os.Stderr is used.
+	Output io.Writer
+	// ConfigFile is the path to an optional configuration file (JSON
+	// by default, see ConfigDecoder), which must contain an object. Its
+	// keys are matched against the names of the global and command flags
+	// and their values replace the defaults for those flags, so they
+	// might still be overridden from the command line. Arrays set the
+	// flag once per element, while objects set map flags using their
+	// key=value pairs. If the file does not exist, it's silently ignored.
+	ConfigFile string
+	// ConfigDecoder, if non-nil, is used to decode ConfigFile into
+	// a *map[string]interface{}, allowing configuration files in any
+	// format. If nil, ConfigFile is decoded as JSON.
+	ConfigDecoder func(io.Reader, interface{}) error
+	// Env enables setting the global and command flags from environment
+	// variables. The variable for each flag is named by EnvPrefix plus
+	// the flag name in uppercase with dashes replaced by underscores (e.g.
+	// MYTOOL_OUTPUT_DIR for -output-dir with EnvPrefix = "MYTOOL_"), unless
+	// the field has an env tag. The values are applied as defaults in the
+	// following order, so each one takes precedence over the previous
+	// ones: the values in the options structs, the values in ConfigFile,
+	// the environment variables and, finally, the command line flags.
+	Env bool
+	// EnvPrefix is prepended to the flag names to determine the names of
+	// the environment variables when Env is enabled.
+	EnvPrefix string
+	// OnSignal, if non-nil, is called when the process receives either
+	// SIGINT or SIGTERM while running a command. After OnSignal returns,
+	// the process exits with a *SignalError passed to Exit. The signal
+	// handler is only installed while the command runs.
+	OnSignal func(os.Signal)
+	// Name is the program name displayed in the help. If empty,
+	// filepath.Base(os.Args[0]) is used.
+	Name string
+	// ErrorFormat indicates how errors returned by command handlers
+	// are printed. Valid values are ErrorFormatText (the default when
+	// empty) and ErrorFormatJSON.
+	ErrorFormat string
+	// DisableAutoHelp disables the automatic help command, so "help"
+	// is treated like any other command name and it's not included
+	// in the list of commands. Note that the list of commands is still
+	// printed when the user provides an unknown command (or no command
+	// at all) as well as when using the -h flag, so you might want to
+	// define your own help command.
+	DisableAutoHelp bool
+	// HelpTemplate, if non-nil, is used to customize the help output.
+	// A template named "command" is executed with a *CommandHelp when
+	// printing the help for a command, while a template named "commands"
+	// is executed with a *Help when listing the available commands. If
+	// any of those templates is not defined, the default format is used
+	// for it. See also HelpTemplateFuncs.
+	HelpTemplate *template.Template
+	// PosixFlags enables expanding clusters of single letter bool
+	// flags, both for global and command flags (e.g. -abc is
+	// interpreted as -a -b -c). The last flag in a cluster might
+	// also be a non-bool flag, taking the rest of the cluster as
+	// its value (e.g. -vn5 is interpreted as -v -n=5).
+	PosixFlags bool
+	// GNUFlags enables parsing global and command flags following the
+	// GNU conventions. Flags with a single letter name are specified
+	// with a single dash and they might be clustered, like when
+	// PosixFlags is enabled, while the rest of the flags must be
+	// specified with two dashes (e.g. --name, --name=value or --name
+	// value). Specifying them with a single dash is an error. The help
+	// shows every flag with the expected number of dashes.
+	GNUFlags bool
+	// HelpWidth, if positive, forces the width used for wrapping the
+	// help text. Otherwise, the width is determined from the COLUMNS
+	// environment variable or, when it's not set, by querying the
+	// terminal, defaulting to 80 when there's no terminal.
+	HelpWidth int
+	// FieldName, if non-nil, is used to determine the flag names for
+	// the fields in the global and command options without a name tag.
+	// The package provides KebabCase (the default), SnakeCase and
+	// LowerCase, but any custom function might be used.
+	FieldName func(string) string
+	// PanicHandler, if non-nil, is called when a command panics with
+	// the command, the recovered value and the full stack trace of the
+	// panicking goroutine. It's called before the panic is converted
+	// to the error returned from Run, so it might be used to report
+	// panics with their complete context.
+	PanicHandler func(cmd *Cmd, recovered interface{}, stack []byte)
+	// NoRecover makes panics in commands crash the program with the
+	// full stack trace, rather than converting them into errors. The
+	// panic is recovered and then raised again, so PanicHandler is
+	// still called. Setting the environment variable named by
+	// LetCommandPanic has the same effect, without calling
+	// PanicHandler, so it can be used for debugging without
+	// changing any code.
+	NoRecover bool
+	// InterspersedFlags allows command flags to appear after positional
+	// arguments (e.g. mytool cmd file -v), like GNU style tools do. By
+	// default, flag parsing stops at the first positional argument.
+	// Arguments after -- are never interpreted as flags.
+	InterspersedFlags bool
+	// StrictFlagOrder, when InterspersedFlags is disabled, makes commands
+	// with options reject arguments which look like flags (other than -
+	// and negative numbers) after the first positional argument and
+	// before --, since they'd be silently treated as positional ones.
+	// Don't enable it if any command accepts positional arguments
+	// starting with a dash.
+	StrictFlagOrder bool
+	// FileRefs enables reading the values of string flags from files.
+	// When enabled, a value starting with @ (e.g. -token @/path/to/file)
+	// is replaced by the contents of the file, with any leading and
+	// trailing whitespace removed. Use @@ to specify a value starting
+	// with a literal @.
+	FileRefs bool
+	// ListLayout, if non-nil, indicates how the list of commands
+	// is aligned. If nil, commands are aligned using a tab width
+	// of 8 and padding with 2 spaces.
+	ListLayout *ListLayout
+	// HelpCommandName is the name of the automatic help command. If
+	// empty, it defaults to "help". Note that the automatic help
+	// command always takes precedence over any command with the same
+	// name, which is reported as an error by Validate. To define your
+	// own help command, set DisableAutoHelp.
+	HelpCommandName string
+	// ShowHiddenFlags includes the flags marked as hidden (see the hidden
+	// tag in Cmd.Options) in the help.
+	ShowHiddenFlags bool
+	// DumpInvocation enables the global -dump-invocation flag (see
+	// DumpInvocationFlag). When provided, the resolved command name, the
+	// values of the global and command flags (after applying defaults
+	// and the configuration file) and the positional arguments are
+	// printed as JSON to the standard output, without running the
+	// command, and ErrHelp is returned.
+	DumpInvocation bool
+	// Silent disables printing any help or error messages to Output,
+	// for callers which want to handle the errors returned from Run
+	// by themselves. The returned errors are not affected.
+	Silent bool
+	// UnknownCommandFunc, if non-nil, is called when the user provides
+	// an unknown command, with its name and the remaining arguments,
+	// instead of printing the available commands. Its return value is
+	// returned from Run. This allows handling unknown commands in
+	// other ways (e.g. running an external tool).
+	UnknownCommandFunc func(name string, args []string) error
+	// PassThroughFunc, if non-nil, is called when the first argument
+	// after the global flags is -- (e.g. mytool -v -- ls -l), with all
+	// the arguments after it, untouched. No command is looked up and
+	// no flags are parsed, nor BeforeFunc or Func are called. Its
+	// return value is returned from Run. This allows tools which wrap
+	// other programs to forward all their arguments.
+	PassThroughFunc func(args []string) error
+	// SortCommands lists the commands sorted by name in the help and
+	// in the JSON dump (see CommandDumpHelpEnvVar), rather than in
+	// the order they were provided. Categories are listed in the order
+	// they first appear in the sorted commands.
+	SortCommands bool
+	// RewriteArgs, if non-nil, is called with the arguments remaining
+	// after parsing the global flags (i.e. the command name followed
+	// by its arguments) after BeforeFunc and before the command is
+	// determined. The arguments it returns are used instead, so it
+	// can be used to expand aliases or inject a default command. If
+	// it returns an error, it's returned from Run.
+	RewriteArgs func(args []string) ([]string, error)
+	// ResponseFiles enables expanding response files in the arguments,
+	// which allows passing arguments which exceed the command line
+	// length limits. When enabled, every argument before -- starting
+	// with @ (e.g. @args.txt) is replaced, before parsing any flags,
+	// by the arguments read from the named file, one per line. Empty
+	// lines are skipped and no quoting is supported. Use @@ to pass an
+	// argument starting with a literal @. When FileRefs is enabled too,
+	// response files are expanded first, so flag values referencing
+	// files must use @@.
+	ResponseFiles bool
+	// WrapErrors makes Run return a *CommandError wrapping any error
+	// returned by the command handler, Cmd.BeforeFunc or Cmd.AfterFunc,
+	// so callers can determine which command failed. ErrHelp is never
+	// wrapped, while Result.Err always contains the unwrapped error.
+	WrapErrors bool
+	// HideHelpInListing omits the automatic help command from the
+	// list of commands, as well as the note explaining how to use it,
+	// while keeping the command working. This is useful when the help
+	// command is documented by other means. To remove the help command
+	// instead, use DisableAutoHelp.
+	HideHelpInListing bool
+	// DumpHelpEnvVar is the name of the environment variable which
+	// makes the tool dump its help as JSON. If empty, it defaults to
+	// CommandDumpHelpEnvVar. Setting it avoids interactions between
+	// tools using this package when one of them runs another one.
+	DumpHelpEnvVar string
+	// Translate, if non-nil, is applied to every string shown in the
+	// help before printing it. This includes the Help, LongHelp, Usage
+	// and Category of the commands, the help and group of the flags,
+	// the help of the arguments, the description of the examples and
+	// the following literals printed by
+	// this package (with their format verbs, which must be kept):
+	//
+	//  "usage:", "Flags", "Global flags", "Arguments", "Examples", "default",
+	//  "one of", "env", "Print this help",
+	//  "arguments after -- are passed through untouched",
+	//  "missing command, available ones are:",
+	//  "unknown command %s, available ones are:",
+	//  "unknown flag: %s",
+	//  "To view additional help for each command use %s <command_name>"
+	//
+	// The help returned by Describe and DescribeCommand is translated
+	// too, but error messages are not.
+	Translate func(string) string
+}
+
+func (opts *Options) dumpHelpEnvVar() string {
+	if opts != nil && opts.DumpHelpEnvVar != "" {
+		return opts.DumpHelpEnvVar
+	}
+	return CommandDumpHelpEnvVar
+}
+
+// wrapError returns err wrapped in a *CommandError
+// for the given command if WrapErrors is enabled.
+func (opts *Options) wrapError(name string, err error) error {
+	if opts == nil || !opts.WrapErrors || err == ErrHelp {
+		return err
+	}
+	return &CommandError{Name: name, Err: err}
+}
+
+// translate returns s translated with Translate, if any.
+// Empty strings are never translated.
+func (opts *Options) translate(s string) string {
+	if opts == nil || opts.Translate == nil || s == "" {
+		return s
+	}
+	return opts.Translate(s)
+}
+
+// displayedCommands returns the commands in the order they
+// should be displayed.
+func (opts *Options) displayedCommands(commands []*Cmd) []*Cmd {
+	if opts == nil || !opts.SortCommands {
+		return commands
+	}
+	sorted := append([]*Cmd(nil), commands...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+// ListLayout contains the parameters used for aligning the list
+// of commands. See text/tabwriter for their meaning. A zero TabWidth
+// or PadChar uses the default one (8 and a space, respectively).
+type ListLayout struct {
+	MinWidth int
+	TabWidth int
+	Padding  int
+	PadChar  byte
+	// AlignRight aligns the command names and their help
+	// to the right of their columns.
+	AlignRight bool
+}
+
+var defaultListLayout = &ListLayout{
+	TabWidth: 8,
+	Padding:  2,
+	PadChar:  ' ',
+}
+
+func (opts *Options) fieldName() func(string) string {
+	if opts != nil && opts.FieldName != nil {
+		return opts.FieldName
+	}
+	return KebabCase
+}
+
+func (opts *Options) listLayout() *ListLayout {
+	if opts == nil || opts.ListLayout == nil {
+		return defaultListLayout
+	}
+	layout := *opts.ListLayout
+	if layout.TabWidth == 0 {
+		layout.TabWidth = defaultListLayout.TabWidth
+	}
+	if layout.PadChar == 0 {
+		layout.PadChar = defaultListLayout.PadChar
+	}
+	return &layout
+}
+
+func (opts *Options) helpWidth() int {
+	if opts != nil && opts.HelpWidth > 0 {
+		return opts.HelpWidth
+	}
+	return terminalWidth()
+}
+
+func (opts *Options) flagArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	if opts != nil && opts.GNUFlags {
+		return gnuFlagArgs(flags, args)
+	}
+	if opts != nil && opts.PosixFlags {
+		return expandFlagClusters(flags, args), nil
+	}
+	return args, nil
+}
+
+// commandFlagArgs is like flagArgs, but it also reorders the
+// flags when InterspersedFlags is enabled. Global flags can't be
+// reordered, since they're followed by the command and its flags.
+func (opts *Options) commandFlagArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	if opts != nil && opts.InterspersedFlags {
+		args = reorderFlags(flags, args, opts.clusters())
+	}
+	return opts.flagArgs(flags, args)
+}
+
+// clusters returns true iff clusters of single
+// letter flags are enabled.
+func (opts *Options) clusters() bool {
+	return opts != nil && (opts.PosixFlags || opts.GNUFlags)
+}
+
+// flagPrefix returns the prefix used for showing the
+// flag with the given name in the help.
+func (opts *Options) flagPrefix(name string) string {
+	if opts != nil && opts.GNUFlags && utf8.RuneCountInString(name) > 1 {
+		return "--"
+	}
+	return "-"
+}
+
+func (opts *Options) autoHelp() bool {
+	return opts == nil || !opts.DisableAutoHelp
+}
+
+func (opts *Options) helpCommand() string {
+	if opts != nil && opts.HelpCommandName != "" {
+		return opts.HelpCommandName
+	}
+	return "help"
+}
+
+// isHelpCommand returns true iff name refers to the
+// automatic help command.
+func (opts *Options) isHelpCommand(name string) bool {
+	return opts.autoHelp() && name == opts.helpCommand()
+}
+
+func (opts *Options) name() string {
+	if opts != nil && opts.Name != "" {
+		return opts.Name
+	}
+	return filepath.Base(os.Args[0])
+}
+
+func (opts *Options) loadConfig() (map[string]interface{}, error) {
+	if opts != nil && opts.ConfigFile != "" {
+		return loadConfig(opts.ConfigFile, opts.ConfigDecoder)
+	}
+	return nil, nil
+}
+
+func (opts *Options) envEnabled() bool {
+	return opts != nil && opts.Env
+}
+
+// envVariable returns the name of the environment variable
+// for the flag with the given name and field.
+func (opts *Options) envVariable(name string, field *reflect.StructField) string {
+	if env := field.Tag.Get("env"); env != "" {
+		return env
+	}
+	var prefix string
+	if opts != nil {
+		prefix = opts.EnvPrefix
+	}
+	return prefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+func (opts *Options) output() io.Writer {
+	if opts != nil && opts.Silent {
+		return ioutil.Discard
+	}
+	if opts != nil && opts.Output != nil {
+		return opts.Output
+	}
+	return os.Stderr
 }
 
 func (opts *Options) additionalCommands() []*Cmd {
@@ -184,128 +862,663 @@ func (opts *Options) additionalCommands() []*Cmd {
 	return nil
 }
 
+func (opts *Options) resolver() CommandResolver {
+	if opts != nil && opts.Options != nil {
+		if resolver, ok := opts.Options.(CommandResolver); ok {
+			return resolver
+		}
+	}
+	return nil
+}
+
+// resolveCommand returns the command with the given name from the
+// CommandResolver, if any.
+func (opts *Options) resolveCommand(name string) (*Cmd, error) {
+	if resolver := opts.resolver(); resolver != nil {
+		return resolver.ResolveCommand(name)
+	}
+	return nil, nil
+}
+
+// listedCommands returns the given commands plus the ones provided
+// by the CommandResolver, if any. Lazy commands are only resolved
+// when their detailed help has been requested in args, otherwise
+// only their name is provided, plus their help when help is true.
+// In both cases, their help is obtained from the CommandResolver
+// when it implements CommandHelper.
+func (opts *Options) listedCommands(args []string, commands []*Cmd, help bool) []*Cmd {
+	resolver := opts.resolver()
+	if resolver == nil {
+		return commands
+	}
+	names := resolver.CommandNames()
+	if len(args) > 1 && opts.isHelpCommand(args[0]) {
+		// Only the detailed help is shown for known commands,
+		// so don't bother obtaining the help for the rest.
+		for _, v := range names {
+			if v == args[1] {
+				help = false
+			}
+		}
+		if commandByName(commands, args[1]) != nil {
+			help = false
+		}
+	}
+	listed := append([]*Cmd(nil), commands...)
+	for _, v := range names {
+		if commandByName(commands, v) != nil {
+			continue
+		}
+		if len(args) > 1 && opts.isHelpCommand(args[0]) && args[1] == v {
+			if cmd, err := resolver.ResolveCommand(v); err == nil && cmd != nil {
+				if helper, ok := resolver.(CommandHelper); ok && cmd.Help == "" {
+					resolved := *cmd
+					resolved.Help = helper.CommandHelp(v)
+					cmd = &resolved
+				}
+				listed = append(listed, cmd)
+				continue
+			}
+		}
+		cmd := &Cmd{Name: v}
+		if helper, ok := resolver.(CommandHelper); ok && help {
+			cmd.Help = helper.CommandHelp(v)
+		}
+		listed = append(listed, cmd)
+	}
+	return listed
+}
+
 // RunOpts tries to run a command from the specified list using the
 // given arguments, interpreting the first argument as the command name.
 // If the returned error is non-nil, it will be one of:
 //
 //  - ErrNoCommand when no arguments are provided
-//  - ErrHelp when the user has requested any help to be shown
+//  - ErrHelp when the user has requested any help to be shown, either via the help
+//    command or the -h flag
 //  - ErrUnusedArguments when the command doesn't accept any arguments, but the user has provided some
+//  - ErrUnknownFlag when the user provides an undefined global flag
 //  - An UnknownCommandError when the command (the first argument) does not exist
+//  - An *AmbiguousCommandError when Options.MatchPrefixes is enabled and the command
+//    matches several commands
+//  - An error loading Options.ConfigFile or applying its values
 //  - Any error returned by Options.BeforeFunc or Options.Func
-//  - Any error returned by the command handler
+//  - Any error returned by Cmd.BeforeFunc
+//  - Any error returned by the command handler or by Cmd.AfterFunc
 //
 // If args is nil, it will be set to os.Args[1:].
 //
-// Any user error will be printed to os.Stderr by RunOpts, so callers don't need to print any
-// error messages themselves.
+// Any user error will be printed to Options.Output (os.Stderr by default) by RunOpts, so
+// callers don't need to print any error messages themselves.
 //
 // Note that RunOpts will panic in case of a programming error. This usually happens
 // when Func or Options don't match the required constraints. See the documentation on
 // those fields in the Cmd type for more information.
-func RunOpts(args []string, opts *Options, commands []*Cmd) (err error) {
-	if os.Getenv(CommandDumpHelpEnvVar) != "" {
-		if err := dumpHelp(os.Stdout, opts, commands); err != nil {
+func RunOpts(args []string, opts *Options, commands []*Cmd) error {
+	return RunContext(context.Background(), args, opts, commands)
+}
+
+// RunContext works like RunOpts, but ctx is passed to any command
+// handlers which accept a context.Context as their first argument (see
+// Cmd.Func). Callers might use ctx to cancel the command execution. If
+// ctx is done before the handler is called, ctx.Err() is returned.
+func RunContext(ctx context.Context, args []string, opts *Options, commands []*Cmd) error {
+	return runContext(ctx, opts.output(), args, opts, commands)
+}
+
+// RunWith works like RunOpts, but every help or error message is
+// written to w, ignoring Options.Output. This allows capturing the
+// output without altering opts. Note that Options.Silent still
+// takes precedence, discarding all the messages.
+func RunWith(w io.Writer, args []string, opts *Options, commands []*Cmd) error {
+	if opts != nil && opts.Silent {
+		w = ioutil.Discard
+	}
+	return runContext(context.Background(), w, args, opts, commands)
+}
+
+func runContext(ctx context.Context, out io.Writer, args []string, opts *Options, commands []*Cmd) error {
+	if dump := os.Getenv(opts.dumpHelpEnvVar()); dump != "" {
+		if err := dumpHelp(os.Stdout, opts, commands, dump); err != nil {
+			if _, ok := err.(UnknownCommandError); ok {
+				fmt.Fprintf(out, "%s\n", err)
+				return err
+			}
 			panic(err)
 		}
 		return nil
 	}
+	if os.Getenv(CommandListEnvVar) != "" {
+		printCommandNames(os.Stdout, opts, commands)
+		return nil
+	}
 	if args == nil {
 		args = os.Args[1:]
 	}
-	rem, err := parseGlobalOptions(args, opts)
+	return run(ctx, out, args, opts, commands, &Result{})
+}
+
+// Result contains information about the execution
+// of a command using RunResult.
+type Result struct {
+	// Command is the name of the command which was
+	// run. It's empty if no command was run.
+	Command string
+	// Help is true when RunResult displayed any help
+	// rather than running a command.
+	Help bool
+	// Err is the error returned by the command handler
+	// (or by Cmd.AfterFunc, if it's non-nil).
+	Err error
+	// Value is the first value returned by command
+	// handlers which return (T, error). Otherwise,
+	// it's nil.
+	Value interface{}
+}
+
+// RunResult works like RunOpts, but returns a *Result with information
+// about the command execution, making it suitable for embedding the command
+// dispatching into another application. In contrast with RunOpts, args is
+// never obtained from os.Args and, unless Options.Output is non-nil, all
+// output is discarded. Note that the environment variable CommandDumpHelpEnvVar
+// is also ignored by RunResult.
+//
+// The returned error follows the same rules than in RunOpts.
+func RunResult(args []string, opts *Options, commands []*Cmd) (*Result, error) {
+	out := ioutil.Discard
+	if opts != nil && opts.Output != nil {
+		out = opts.output()
+	}
+	result := &Result{}
+	err := run(context.Background(), out, args, opts, commands, result)
+	return result, err
+}
+
+func run(ctx context.Context, out io.Writer, args []string, opts *Options, commands []*Cmd, result *Result) (err error) {
+	config, err := opts.loadConfig()
 	if err != nil {
+		fmt.Fprintf(out, "%s\n", err)
 		return err
 	}
-	if opts != nil && opts.BeforeFunc != nil {
+	if opts != nil && opts.ResponseFiles {
+		if args, err = expandResponseFiles(args); err != nil {
+			fmt.Fprintf(out, "%s\n", err)
+			return err
+		}
+	}
+	rem, inv, err := parseGlobalOptions(out, args, opts, config)
+	if err != nil {
+		if err == flag.ErrHelp {
+			result.Help = true
+			PrintHelp(out, opts.listedCommands(nil, commands, true), opts)
+			return ErrHelp
+		}
+		return err
+	}
+	if opts != nil && opts.PassThroughFunc != nil && len(rem) > 0 && rem[0] == "--" {
+		return opts.PassThroughFunc(rem[1:])
+	}
+	if opts != nil && opts.BeforeFunc != nil && !skipGlobalHooks(opts, rem, commands) {
 		if err := opts.BeforeFunc(opts); err != nil {
 			return err
 		}
 	}
 	commands = append(commands, opts.additionalCommands()...)
-	if len(rem) == 0 || rem[0] == "help" {
-		return printHelp(os.Stderr, rem, commands)
+	if len(rem) > 0 && rem[0] == CompleteCommand && commandByName(commands, CompleteCommand) == nil {
+		return complete(os.Stdout, opts, rem[1:], commands)
+	}
+	if opts != nil && opts.RewriteArgs != nil {
+		if rem, err = opts.RewriteArgs(rem); err != nil {
+			return err
+		}
+	}
+	if len(rem) == 0 || opts.isHelpCommand(rem[0]) {
+		result.Help = true
+		return printHelp(out, opts, rem, opts.listedCommands(rem, commands, true))
 	}
 	name := rem[0]
 	cmdArgs := rem[1:]
-	cmd := commandByName(commands, name)
+	cmd, err := resolveCommand(commands, name, opts != nil && opts.MatchPrefixes)
+	if err == nil && cmd == nil {
+		cmd, err = opts.resolveCommand(name)
+	}
+	if err != nil {
+		fmt.Fprintf(out, "%s\n", err)
+		return err
+	}
 	if cmd == nil {
-		return printHelp(os.Stderr, args, commands)
+		if opts != nil && opts.UnknownCommandFunc != nil {
+			return opts.UnknownCommandFunc(name, cmdArgs)
+		}
+		result.Help = true
+		return printHelp(out, opts, rem, opts.listedCommands(rem, commands, true))
+	}
+	result.Command = cmd.Name
+	return runCommand(ctx, out, opts, cmd, cmdArgs, config, result, inv)
+}
+
+// skipGlobalHooks returns true iff the command selected by args
+// is found in commands and has SkipGlobalHooks set.
+func skipGlobalHooks(opts *Options, args []string, commands []*Cmd) bool {
+	if len(args) == 0 || opts.isHelpCommand(args[0]) {
+		return false
+	}
+	cmd, err := resolveCommand(commands, args[0], opts != nil && opts.MatchPrefixes)
+	return err == nil && cmd != nil && cmd.SkipGlobalHooks
+}
+
+// runCommand parses the flags for the given command, validates its
+// arguments and then runs it.
+// If inv is non-nil, the resolved invocation is dumped instead of
+// running the command.
+func runCommand(ctx context.Context, out io.Writer, opts *Options, cmd *Cmd, cmdArgs []string, config map[string]interface{}, result *Result, inv *invocation) (err error) {
+	name := cmd.Name
+	defer recoverRun(out, opts, cmd, &err)
+	if cmd.Func == nil {
+		// Commands without a handler just show their help
+		result.Help = true
+		PrintCommandHelp(out, cmd, opts)
+		return ErrHelp
 	}
-	defer recoverRun(cmd, &err)
 	fn := reflect.ValueOf(cmd.Func)
 	if fn.Kind() != reflect.Func {
 		panic(fmt.Errorf("command handler %s is not a function, it's %T", name, cmd.Func))
 	}
 	if err := validateCmdFuncReturn(fn); err != nil {
-		panic(fmt.Errorf("invalid handler for command %s: %s", name, err))
+		panic(fmt.Errorf("invalid handler for command %q: %s", name, err))
 	}
 	var optsVal reflect.Value
-	if cmd.Options != nil {
-		optsVal = reflect.ValueOf(cmd.Options)
-		flags, err := setupOptionsFlags(name, optsVal)
+	var passThrough []string
+	var cmdFlags map[string]string
+	var fvalues *FlagValues
+	if options := cmd.options(); len(options) > 0 {
+		if cmd.Options != nil {
+			optsVal = reflect.ValueOf(cmd.Options)
+		}
+		svals := make([]reflect.Value, len(options))
+		for ii, v := range options {
+			setDefaults(v)
+			svals[ii] = reflect.ValueOf(v)
+		}
+		if err := configureOptions(opts, name, config, svals...); err != nil {
+			fmt.Fprintf(out, "%s\n", err)
+			return err
+		}
+		if opts != nil && opts.FlagsFunc != nil {
+			var err error
+			if fvalues, err = newFlagValues(opts, svals...); err != nil {
+				panic(err)
+			}
+		}
+		flags, err := setupOptionsFlags(opts, name, svals...)
 		if err != nil {
 			panic(err)
 		}
-		if err := flags.Parse(cmdArgs); err != nil {
+		// The flag package calls Usage after printing any
+		// errors as well as when -h is provided.
+		flags.Usage = func() {
+			result.Help = true
+			PrintCommandHelp(out, cmd, opts)
+		}
+		flags.SetOutput(out)
+		// Negative numbers (e.g. -5) are positional arguments
+		// unless there's a flag with the same name.
+		parsedArgs, err := opts.commandFlagArgs(flags, cmdArgs)
+		if err != nil {
+			fmt.Fprintf(out, "%s\n", err)
+			flags.Usage()
 			return err
 		}
-		cmdArgs = flags.Args()
+		if opts != nil && opts.StrictFlagOrder && !opts.InterspersedFlags {
+			if err := checkFlagOrder(flags, parsedArgs); err != nil {
+				fmt.Fprintf(out, "%s\n", err)
+				return err
+			}
+		}
+		flagArgs, numbers := splitNumberArgs(flags, parsedArgs, opts.clusters())
+		if err := flags.Parse(flagArgs); err != nil {
+			if err == flag.ErrHelp {
+				return ErrHelp
+			}
+			return err
+		}
+		cmdFlags = flagValues(flags)
+		rest := append(flags.Args(), numbers...)
+		passThrough = passThroughArgs(rest, consumesSeparator(flags, flagArgs))
+		cmdArgs = rest
+	} else {
+		if len(cmdArgs) > 0 && isHelpFlag(cmdArgs[0]) && !cmd.RawArgs {
+			result.Help = true
+			PrintCommandHelp(out, cmd, opts)
+			return ErrHelp
+		}
+		if cmd.StrictFlags && !cmd.RawArgs {
+			for _, v := range cmdArgs {
+				if v == "--" {
+					break
+				}
+				if len(v) > 1 && v[0] == '-' && !isNegativeNumber(v) {
+					fmt.Fprintf(out, "command %s takes no flags\n\n", name)
+					result.Help = true
+					PrintCommandHelp(out, cmd, opts)
+					return ErrUnknownFlag
+				}
+			}
+		}
+		passThrough = passThroughArgs(cmdArgs, false)
 	}
 	cmdArguments := newArgs(cmdArgs, cmd)
-	if err := validateCmdFuncInput(fn, optsVal); err != nil {
-		panic(fmt.Errorf("invalid handler for command %s: %s", name, err))
+	cmdArguments.passThrough = passThrough
+	var globalVal reflect.Value
+	if opts != nil && opts.Options != nil {
+		globalVal = reflect.ValueOf(opts.Options)
+	}
+	if err := validateCmdFuncInput(fn, optsVal, globalVal); err != nil {
+		panic(fmt.Errorf("invalid handler for command %q: %s", name, err))
 	}
-	if opts != nil && opts.Func != nil {
+	if opts != nil && opts.Func != nil && !cmd.SkipGlobalHooks {
 		if err := opts.Func(cmd, opts); err != nil {
 			return err
 		}
 	}
+	if opts != nil && opts.FlagsFunc != nil && !cmd.SkipGlobalHooks {
+		if err := opts.FlagsFunc(cmd, opts, fvalues); err != nil {
+			return err
+		}
+	}
 	if err := cmdArguments.validate(); err != nil {
 		if err == ErrUnusedArguments {
-			fmt.Fprintf(os.Stderr, "command %s does not accept any arguments\n", name)
+			fmt.Fprintf(out, "command %s does not accept any arguments (got: %s)\n\n", name, strings.Join(cmdArguments.Args(), " "))
+			result.Help = true
+			PrintCommandHelp(out, cmd, opts)
+		} else {
+			fmt.Fprintf(out, "%s\n", err)
 		}
 		return err
 	}
-	fnArgs := []reflect.Value{reflect.ValueOf(cmdArguments)}
+	if err := cmdArguments.expandStdin(os.Stdin); err != nil {
+		fmt.Fprintf(out, "%s\n", err)
+		return err
+	}
+	if inv != nil {
+		inv.Command = name
+		inv.Flags = cmdFlags
+		inv.Args = cmdArguments.Args()
+		if err := inv.dump(os.Stdout); err != nil {
+			return err
+		}
+		result.Help = true
+		return ErrHelp
+	}
+	if opts != nil && opts.OnSignal != nil {
+		stop := handleSignals(opts.OnSignal)
+		defer stop()
+	}
+	if cmd.BeforeFunc != nil {
+		if err := cmd.BeforeFunc(cmdArguments); err != nil {
+			return opts.wrapError(name, err)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var fnArgs []reflect.Value
+	if takesContext(fn.Type()) {
+		fnArgs = append(fnArgs, reflect.ValueOf(ctx))
+	}
+	fnArgs = append(fnArgs, reflect.ValueOf(cmdArguments))
 	if optsVal.IsValid() {
-		fnArgs = append(fnArgs, optsVal)
+		fnArgs = append(fnArgs, optionsArg(fn.Type().In(len(fnArgs)), optsVal))
+	}
+	if len(fnArgs) < fn.Type().NumIn() {
+		fnArgs = append(fnArgs, optionsArg(fn.Type().In(len(fnArgs)), globalVal))
 	}
+	var cmdErr error
 	res := fn.Call(fnArgs)
 	if len(res) > 0 {
-		if err, ok := res[0].Interface().(error); ok {
-			fmt.Fprintf(os.Stderr, "error running command %s: %s\n", name, err)
-			return err
-		}
+		cmdErr, _ = res[len(res)-1].Interface().(error)
+	}
+	if len(res) > 1 {
+		result.Value = res[0].Interface()
+	}
+	if cmd.AfterFunc != nil {
+		cmdErr = cmd.AfterFunc(cmdArguments, cmdErr)
+	}
+	result.Err = cmdErr
+	if cmdErr == ErrHelp {
+		result.Help = true
+		PrintCommandHelp(out, cmd, opts)
+		return cmdErr
+	}
+	if cmdErr != nil {
+		printCommandError(out, opts, name, cmdErr)
+		return opts.wrapError(name, cmdErr)
 	}
 	return nil
 }
 
-func parseGlobalOptions(args []string, opts *Options) ([]string, error) {
+// Invoke runs the command with the given name from commands, using args
+// as its arguments, which might include flags for the command. The
+// command options are parsed into a deep copy of its Options and
+// SharedOptions fields, so invoking a command doesn't alter the options
+// of any command run before. Note that global options are not handled
+// at all, so handlers must pass any required state by other means (in
+// particular, commands whose handler receives the global options can't
+// be invoked).
+//
+// Invoke is intended to be used from command handlers which need to
+// run other commands. It always uses the default Options (as if nil
+// was passed to RunOpts), so flags are named after the fields as
+// usual and any errors are printed to os.Stderr. If the command does
+// not exist, an UnknownCommandError is returned.
+func Invoke(commands []*Cmd, name string, args []string) error {
+	cmd := commandByName(commands, name)
+	if cmd == nil {
+		return UnknownCommandError(name)
+	}
+	invoked := *cmd
+	invoked.Options = copyOptions(cmd.Options)
+	if len(cmd.SharedOptions) > 0 {
+		invoked.SharedOptions = make([]interface{}, len(cmd.SharedOptions))
+		for ii, v := range cmd.SharedOptions {
+			invoked.SharedOptions[ii] = copyOptions(v)
+		}
+	}
+	return runCommand(context.Background(), os.Stderr, nil, &invoked, args, nil, &Result{}, nil)
+}
+
+// copyOptions returns a deep copy of the given options, which
+// must be a pointer to a struct. Any other value is returned as is.
+func copyOptions(options interface{}) interface{} {
+	if options == nil {
+		return nil
+	}
+	val := reflect.ValueOf(options)
+	if val.Kind() != reflect.Ptr || val.IsNil() {
+		return options
+	}
+	return deepCopy(val).Interface()
+}
+
+// deepCopy returns a copy of val which doesn't share any pointers,
+// maps nor slices with it. Unexported fields and interfaces are
+// copied as is.
+func deepCopy(val reflect.Value) reflect.Value {
+	switch val.Kind() {
+	case reflect.Ptr:
+		if val.IsNil() {
+			return val
+		}
+		cpy := reflect.New(val.Type().Elem())
+		cpy.Elem().Set(deepCopy(val.Elem()))
+		return cpy
+	case reflect.Struct:
+		cpy := reflect.New(val.Type()).Elem()
+		cpy.Set(val)
+		for ii := 0; ii < cpy.NumField(); ii++ {
+			if field := cpy.Field(ii); field.CanSet() {
+				field.Set(deepCopy(val.Field(ii)))
+			}
+		}
+		return cpy
+	case reflect.Map:
+		if val.IsNil() {
+			return val
+		}
+		cpy := reflect.MakeMapWithSize(val.Type(), val.Len())
+		for _, k := range val.MapKeys() {
+			cpy.SetMapIndex(k, deepCopy(val.MapIndex(k)))
+		}
+		return cpy
+	case reflect.Slice:
+		if val.IsNil() {
+			return val
+		}
+		cpy := reflect.MakeSlice(val.Type(), val.Len(), val.Len())
+		for ii := 0; ii < val.Len(); ii++ {
+			cpy.Index(ii).Set(deepCopy(val.Index(ii)))
+		}
+		return cpy
+	}
+	return val
+}
+
+// parseGlobalOptions parses the global options and returns the remaining
+// arguments. If Options.DumpInvocation is enabled and the user requested
+// it, the returned *invocation is non-nil.
+func parseGlobalOptions(out io.Writer, args []string, opts *Options, config map[string]interface{}) ([]string, *invocation, error) {
+	var dump bool
 	if opts != nil && opts.Options != nil {
+		setDefaults(opts.Options)
 		globalOptsVal := reflect.ValueOf(opts.Options)
-		flags, err := setupOptionsFlags("", globalOptsVal)
+		if err := configureOptions(opts, "", config, globalOptsVal); err != nil {
+			fmt.Fprintf(out, "%s\n", err)
+			return nil, nil, err
+		}
+		flags, err := setupOptionsFlags(opts, "", globalOptsVal)
 		if err != nil {
 			panic(err)
 		}
-		if err := flags.Parse(args); err != nil {
-			return nil, err
+		if opts.DumpInvocation && flags.Lookup(DumpInvocationFlag) == nil {
+			flags.BoolVar(&dump, DumpInvocationFlag, false, "")
+		}
+		// The command list is printed by RunOpts when -h is provided,
+		// while errors are printed below.
+		flags.Usage = func() {}
+		flags.SetOutput(ioutil.Discard)
+		parsedArgs, err := opts.flagArgs(flags, args)
+		if err != nil {
+			fmt.Fprintf(out, "%s\n", err)
+			return nil, nil, err
+		}
+		if err := flags.Parse(parsedArgs); err != nil {
+			if err == flag.ErrHelp {
+				return nil, nil, err
+			}
+			if name, ok := undefinedFlag(err); ok {
+				fmt.Fprintf(out, opts.translate("unknown flag: %s")+"\n", opts.flagPrefix(name)+name)
+				if flags, err := flagsHelp(opts, opts.Options); err == nil {
+					printFlags(out, opts, "Global flags", flags)
+				}
+				return nil, nil, ErrUnknownFlag
+			}
+			fmt.Fprintf(out, "%s\n", err)
+			return nil, nil, err
 		}
 		args = flags.Args()
+		if opts.PassThroughFunc != nil && consumesSeparator(flags, parsedArgs) {
+			// Keep the separator, so RunOpts can tell it apart
+			// from a command.
+			args = append([]string{"--"}, args...)
+		}
+		if dump {
+			return args, &invocation{GlobalFlags: flagValues(flags)}, nil
+		}
+		return args, nil, nil
 	}
-	return args, nil
+	if opts != nil && opts.DumpInvocation {
+		args, dump = stripDumpInvocation(args)
+	}
+	if len(args) > 0 && isHelpFlag(args[0]) {
+		// Without global options, the flag package never
+		// sees the help flag, so handle it here.
+		return nil, nil, flag.ErrHelp
+	}
+	if dump {
+		return args, &invocation{}, nil
+	}
+	return args, nil, nil
 }
 
-func validateCmdFuncInput(fn reflect.Value, optsVal reflect.Value) error {
+// isHelpFlag returns true iff arg is any of the forms
+// the flag package accepts for requesting help. Note that
+// this should only be used when there's no flag.FlagSet
+// involved, since a FlagSet might define its own -h flag.
+func isHelpFlag(arg string) bool {
+	switch arg {
+	case "-h", "--h", "-help", "--help":
+		return true
+	}
+	return false
+}
+
+// undefinedFlag returns the flag name and true iff the given
+// error was returned by the flag package because the flag
+// was not defined.
+func undefinedFlag(err error) (string, bool) {
+	const prefix = "flag provided but not defined: -"
+	if msg := err.Error(); strings.HasPrefix(msg, prefix) {
+		return msg[len(prefix):], true
+	}
+	return "", false
+}
+
+// takesContext returns true iff the given handler function
+// type receives a context.Context as its first argument.
+func takesContext(fnTyp reflect.Type) bool {
+	return fnTyp.NumIn() > 0 && fnTyp.In(0) == contextType
+}
+
+// acceptsOptions returns true iff typ, the type of a handler argument,
+// can receive the options in val, either as a pointer or as a value.
+func acceptsOptions(typ reflect.Type, val reflect.Value) bool {
+	return typ == val.Type() || typ == val.Type().Elem()
+}
+
+// optionsArg returns the value passed to a handler argument of
+// type typ for receiving the options in val.
+func optionsArg(typ reflect.Type, val reflect.Value) reflect.Value {
+	if typ == val.Type() {
+		return val
+	}
+	// Handler takes the options by value
+	return val.Elem()
+}
+
+func validateCmdFuncInput(fn reflect.Value, optsVal reflect.Value, globalVal reflect.Value) error {
 	argsType := reflect.TypeOf((*Args)(nil))
 	fnTyp := fn.Type()
 	numIn := fnTyp.NumIn()
-	if numIn < 1 || fnTyp.In(0) != argsType {
-		return fmt.Errorf("function %s must accept %s as its first argument", funcName(fn), argsType)
+	argsPos := 0
+	if takesContext(fnTyp) {
+		argsPos++
+	}
+	if numIn < argsPos+1 || fnTyp.In(argsPos) != argsType {
+		return fmt.Errorf("%s must accept %s as its first argument", funcName(fn), argsType)
 	}
+	pos := argsPos + 1
 	if optsVal.IsValid() {
-		if numIn < 2 || fnTyp.In(1) != optsVal.Type() {
-			return fmt.Errorf("function %s must accept %s as its second argument", funcName(fn), optsVal.Type())
+		if numIn < pos+1 || !acceptsOptions(fnTyp.In(pos), optsVal) {
+			return fmt.Errorf("%s must accept either %s or %s after %s", funcName(fn), optsVal.Type(), optsVal.Type().Elem(), argsType)
+		}
+		pos++
+	}
+	if numIn > pos {
+		if !globalVal.IsValid() || numIn > pos+1 {
+			return fmt.Errorf("%s accepts too many arguments", funcName(fn))
+		}
+		if !acceptsOptions(fnTyp.In(pos), globalVal) {
+			return fmt.Errorf("%s must accept either %s or %s as the global options", funcName(fn), globalVal.Type(), globalVal.Type().Elem())
 		}
 	}
 	return nil
@@ -317,26 +1530,50 @@ func validateCmdFuncReturn(val reflect.Value) error {
 	if numOut == 0 {
 		return nil
 	}
-	if numOut > 1 {
-		return fmt.Errorf("function %s must return 0 or 1 arguments, not %d", funcName(val), numOut)
+	if numOut > 2 {
+		return fmt.Errorf("%s must return 0, 1 or 2 arguments, not %d", funcName(val), numOut)
 	}
-	if typ.Out(0) != errType {
-		return fmt.Errorf("function %s must return a value of type %s, not %s", funcName(val), errType, typ.Out(0))
+	if last := typ.Out(numOut - 1); last != errType {
+		return fmt.Errorf("%s must return a value of type %s as its last result, not %s", funcName(val), errType, last)
 	}
 	return nil
 }
 
+// funcName returns a description of the given function to be used
+// in error messages. Closures and method values have unhelpful
+// names (e.g. main.glob..func3), so they're described just as
+// "handler" and the error is expected to include the command name.
 func funcName(val reflect.Value) string {
-	ptr := val.Pointer()
-	fn := runtime.FuncForPC(ptr)
-	if fn == nil {
-		return fmt.Sprintf("unknown function at %p", ptr)
+	fn := runtime.FuncForPC(val.Pointer())
+	if fn == nil || isClosureName(fn.Name()) {
+		return "handler"
 	}
-	return fn.Name()
+	return "function " + fn.Name()
 }
 
-func setupOptionsFlags(name string, sval reflect.Value) (*flag.FlagSet, error) {
-	arg0 := filepath.Base(os.Args[0])
+// isClosureName returns true iff name is the name the runtime
+// assigns to a closure (pkg.fn.func1) or a method value (pkg.T.M-fm).
+func isClosureName(name string) bool {
+	if strings.HasSuffix(name, "-fm") {
+		return true
+	}
+	p := strings.LastIndex(name, ".func")
+	if p < 0 {
+		return false
+	}
+	rest := name[p+len(".func"):]
+	return rest != "" && rest[0] >= '0' && rest[0] <= '9'
+}
+
+func setupOptionsFlags(opts *Options, name string, svals ...reflect.Value) (*flag.FlagSet, error) {
+	return setupFlags(opts, name, nil, svals...)
+}
+
+// setupFlags works like setupOptionsFlags, but if fieldErr is non-nil
+// it's called with any error caused by a field, allowing the caller to
+// continue processing the rest of the fields when fieldErr returns nil.
+func setupFlags(opts *Options, name string, fieldErr func(error) error, svals ...reflect.Value) (*flag.FlagSet, error) {
+	arg0 := opts.name()
 	var flagsName string
 	if name != "" {
 		flagsName = fmt.Sprintf("%s %s subcommand", arg0, name)
@@ -344,42 +1581,87 @@ func setupOptionsFlags(name string, sval reflect.Value) (*flag.FlagSet, error) {
 		flagsName = arg0
 	}
 	flags := flag.NewFlagSet(flagsName, flag.ContinueOnError)
-	err := visitStruct(sval, func(name string, help string, field *reflect.StructField, val reflect.Value, ptr interface{}) error {
+	var boolFlags []string
+	var boolValues []reflect.Value
+	register := func(name string, help string, field *reflect.StructField, val reflect.Value, ptr interface{}) error {
 		if value, ok := ptr.(flag.Value); ok {
 			flags.Var(value, name, help)
 			return nil
 		}
+		if val.Type() == timeType {
+			flags.Var(&timeValue{val: val, layout: fieldLayout(field)}, name, help)
+			return nil
+		}
+		if val.Type() == urlType {
+			flags.Var(&urlValue{val: val}, name, help)
+			return nil
+		}
 		switch val.Type().Kind() {
 		case reflect.Bool:
 			flags.BoolVar(ptr.(*bool), name, val.Bool(), help)
+			boolFlags = append(boolFlags, name)
+			boolValues = append(boolValues, val)
 		case reflect.Float64:
 			flags.Float64Var(ptr.(*float64), name, val.Float(), help)
-		case reflect.Int:
-			flags.IntVar(ptr.(*int), name, int(val.Int()), help)
-		case reflect.Uint:
-			flags.UintVar(ptr.(*uint), name, uint(val.Uint()), help)
-		case reflect.Int64:
-			flags.Int64Var(ptr.(*int64), name, val.Int(), help)
-		case reflect.Uint64:
-			flags.Uint64Var(ptr.(*uint64), name, val.Uint(), help)
 		case reflect.String:
-			flags.StringVar(ptr.(*string), name, val.String(), help)
+			var choices *choiceValue
+			if c := fieldChoices(field); c != nil {
+				choices = &choiceValue{val: val, choices: c}
+				if def := val.String(); def != "" && !choices.isValid(def) {
+					return fmt.Errorf("field %s has default value %q, which is not one of its choices (%s)", field.Name, def, strings.Join(c, ", "))
+				}
+			}
+			switch {
+			case opts != nil && opts.FileRefs:
+				flags.Var(&fileRefValue{val: val, choices: choices}, name, help)
+			case choices != nil:
+				flags.Var(choices, name, help)
+			default:
+				flags.StringVar(ptr.(*string), name, val.String(), help)
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			flags.Var(&intValue{val: val}, name, help)
+		case reflect.Map:
+			if !isStringMap(val.Type()) {
+				return fmt.Errorf("field %s has invalid option type %s", field.Name, field.Type)
+			}
+			if val.IsNil() {
+				val.Set(reflect.MakeMap(val.Type()))
+			}
+			flags.Var(&mapValue{val: val}, name, help)
+		case reflect.Ptr:
+			if !isBasicKind(val.Type().Elem().Kind()) {
+				return fmt.Errorf("field %s has invalid option type %s", field.Name, field.Type)
+			}
+			flags.Var(&pointerValue{val: val}, name, help)
 		default:
 			return fmt.Errorf("field %s has invalid option type %s", field.Name, field.Type)
 		}
 		return nil
+	}
+	err := visitStructs(svals, opts.fieldName(), func(name string, help string, field *reflect.StructField, val reflect.Value, ptr interface{}) error {
+		err := register(name, help, field, val, ptr)
+		if err != nil && fieldErr != nil {
+			return fieldErr(err)
+		}
+		return err
 	})
-	switch err {
-	case errNoPointer:
-		if name != "" {
-			return nil, fmt.Errorf("invalid command %s options %s, must be a pointer", name, sval.Type())
+	if err == nil {
+		// Register the negated bool flags after all the fields have
+		// been visited, so explicitly declared flags take precedence.
+		for ii, v := range boolFlags {
+			if negated := "no-" + v; flags.Lookup(negated) == nil {
+				flags.Var(&negatedBoolValue{val: boolValues[ii]}, negated, fmt.Sprintf("Disable -%s", v))
+			}
 		}
-		return nil, fmt.Errorf("invalid options %s, must be a pointer", sval.Type())
-	case errNoStruct:
-		if name != "" {
-			return nil, fmt.Errorf("command %s options field is not a struct, it's %T", name, sval.Type())
+	}
+	if err == errNoPointer || err == errNoStruct {
+		for _, v := range svals {
+			if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+				return nil, &InvalidOptionsError{Command: name, Type: v.Type()}
+			}
 		}
-		return nil, fmt.Errorf("options field is not a struct, it's %T", sval.Type())
 	}
 	return flags, err
 }
@@ -393,71 +1675,207 @@ func commandByName(commands []*Cmd, name string) *Cmd {
 	return nil
 }
 
-func printCommandHelp(w io.Writer, cmd *Cmd) {
-	fmt.Fprintf(w, "%s: %s\n", cmd.Name, cmd.Help)
-	if cmd.Usage != "" || cmd.hasArgs() {
-		fmt.Fprintf(w, "usage: %s %s", filepath.Base(os.Args[0]), cmd.Name)
+// resolveCommand returns the command matching the given name. If
+// there's no exact match and prefixes is true, a command which has
+// name as an unique prefix is also accepted. If several commands share
+// the prefix, an *AmbiguousCommandError is returned.
+func resolveCommand(commands []*Cmd, name string, prefixes bool) (*Cmd, error) {
+	if cmd := commandByName(commands, name); cmd != nil || !prefixes {
+		return cmd, nil
+	}
+	var matches []*Cmd
+	for _, v := range commands {
+		if strings.HasPrefix(v.Name, name) {
+			matches = append(matches, v)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, nil
+	case 1:
+		return matches[0], nil
+	}
+	candidates := make([]string, len(matches))
+	for ii, v := range matches {
+		candidates[ii] = v.Name
+	}
+	return nil, &AmbiguousCommandError{Name: name, Candidates: candidates}
+}
+
+func printCommandError(w io.Writer, opts *Options, name string, err error) {
+	if opts != nil && opts.ErrorFormat == ErrorFormatJSON {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   err.Error(),
+			"command": name,
+			"code":    StatusFor(err),
+		})
+		return
+	}
+	fmt.Fprintf(w, "error running command %s: %s\n", name, err)
+}
+
+// PrintCommandHelp prints the detailed help for the given command to w,
+// the same one shown by the help command. opts might be nil.
+func PrintCommandHelp(w io.Writer, cmd *Cmd, opts *Options) {
+	if opts != nil && opts.HelpTemplate != nil {
+		if h, err := commandHelp(opts, cmd); err == nil && executeHelpTemplate(w, opts, "command", h) {
+			return
+		}
+	}
+	fmt.Fprintf(w, "%s: %s\n", cmd.Name, opts.translate(cmd.Help))
+	if cmd.Usage != "" || cmd.hasArgs() || cmd.PassThrough {
+		fmt.Fprintf(w, "%s %s %s", opts.translate("usage:"), opts.name(), cmd.Name)
 		if cmd.Usage != "" {
-			fmt.Fprintf(w, " %s", cmd.Usage)
+			fmt.Fprintf(w, " %s", opts.translate(cmd.Usage))
 		}
 		for _, v := range cmd.Args {
+			if v == nil {
+				continue
+			}
 			if v.Optional {
 				fmt.Fprintf(w, " [%s]", v.Name)
 			} else {
 				fmt.Fprintf(w, " %s", v.Name)
 			}
 		}
+		if cmd.PassThrough {
+			fmt.Fprint(w, " [-- args...]")
+		}
 		fmt.Fprint(w, "\n")
+		if cmd.PassThrough {
+			fmt.Fprintf(w, "%s\n", opts.translate("arguments after -- are passed through untouched"))
+		}
 	}
 	if cmd.LongHelp != "" {
-		fmt.Fprintf(w, "\n%s\n", cmd.LongHelp)
+		fmt.Fprintf(w, "\n%s\n", wrapText(opts.translate(cmd.LongHelp), opts.helpWidth()))
 	}
-	if cmd.Options != nil {
-		fmt.Fprint(w, "\nFlags:\n")
-		opts := reflect.ValueOf(cmd.Options)
-		if fs, err := setupOptionsFlags(cmd.Name, opts); err == nil {
-			fs.PrintDefaults()
+	if options := cmd.options(); len(options) > 0 {
+		if flags, err := flagsHelp(opts, options...); err == nil {
+			printFlags(w, opts, "Flags", flags)
 		}
 	}
 	if cmd.hasArgs() {
-		fmt.Fprint(w, "\nArguments:\n")
+		fmt.Fprintf(w, "\n%s:\n", opts.translate("Arguments"))
 		for _, v := range cmd.Args {
-			fmt.Fprintf(w, "  %s: %s\n", v.Name, v.Help)
+			if v == nil {
+				continue
+			}
+			fmt.Fprintf(w, "  %s", v.Name)
+			if v.Type != "" {
+				fmt.Fprintf(w, " (%s)", v.Type)
+			}
+			fmt.Fprintf(w, ": %s", opts.translate(v.Help))
+			if v.Default != "" {
+				fmt.Fprintf(w, " (%s %q)", opts.translate("default"), v.Default)
+			}
+			fmt.Fprint(w, "\n")
+		}
+	}
+	if len(cmd.Examples) > 0 {
+		fmt.Fprintf(w, "\n%s:\n", opts.translate("Examples"))
+		for _, v := range cmd.Examples {
+			if v.Description != "" {
+				fmt.Fprintf(w, "  %s\n    %s\n", opts.translate(v.Description), v.Command)
+			} else {
+				fmt.Fprintf(w, "  %s\n", v.Command)
+			}
 		}
 	}
 }
 
-func printHelp(w io.Writer, args []string, commands []*Cmd) error {
+func printHelp(w io.Writer, opts *Options, args []string, commands []*Cmd) error {
 	var err error
 	if len(args) == 0 {
-		fmt.Fprintln(w, "missing command, available ones are:\n")
+		fmt.Fprintln(w, opts.translate("missing command, available ones are:"))
+		fmt.Fprintln(w)
 		err = ErrNoCommand
 	} else {
 		var unknown string
-		if args[0] != "help" && commandByName(commands, args[0]) == nil {
+		if !opts.isHelpCommand(args[0]) && commandByName(commands, args[0]) == nil {
 			unknown = args[0]
 		}
-		if len(args) > 1 && args[0] == "help" {
+		if len(args) > 1 && opts.isHelpCommand(args[0]) {
 			if cmd := commandByName(commands, args[1]); cmd != nil {
-				printCommandHelp(w, cmd)
+				PrintCommandHelp(w, cmd, opts)
 				return ErrHelp
 			}
 			unknown = args[1]
 		}
 		if unknown != "" {
-			fmt.Fprintf(w, "unknown command %s, available ones are:\n\n", unknown)
+			fmt.Fprintf(w, opts.translate("unknown command %s, available ones are:")+"\n\n", unknown)
 			err = UnknownCommandError(unknown)
 		}
 	}
-	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
-	for _, v := range commands {
-		fmt.Fprintf(tw, "%s\t%s\n", v.Name, v.Help)
-	}
-	fmt.Fprint(tw, "help\tPrint this help\n")
-	tw.Flush()
-	fmt.Fprint(w, "\nTo view additional help for each command use help <command_name>\n")
+	PrintHelp(w, commands, opts)
 	if err == nil {
 		err = ErrHelp
 	}
 	return err
 }
+
+// PrintHelp prints the list of the given commands to w, the same one
+// shown by the help command when no command name is provided. opts
+// might be nil.
+func PrintHelp(w io.Writer, commands []*Cmd, opts *Options) {
+	if opts != nil && opts.HelpTemplate != nil {
+		if h, err := Describe(commands, opts); err == nil && executeHelpTemplate(w, opts, "commands", h) {
+			return
+		}
+	}
+	// Only the listing is affected by HideHelpInListing
+	autoHelp := opts.autoHelp() && (opts == nil || !opts.HideHelpInListing)
+	commands = opts.displayedCommands(commands)
+	var categories []string
+	categorized := make(map[string][]*Cmd)
+	for _, v := range commands {
+		if _, found := categorized[v.Category]; !found && v.Category != "" {
+			categories = append(categories, v.Category)
+		}
+		categorized[v.Category] = append(categorized[v.Category], v)
+	}
+	// Wrap the help using the widest name, indented categorized
+	// names included, to keep it aligned.
+	helpName := opts.helpCommand()
+	var nameWidth int
+	if autoHelp {
+		nameWidth = len(helpName)
+	}
+	for _, v := range commands {
+		n := len(v.Name)
+		if v.Category != "" {
+			n += 2
+		}
+		if n > nameWidth {
+			nameWidth = n
+		}
+	}
+	layout := opts.listLayout()
+	helpWidth := opts.helpWidth() - nameWidth - layout.Padding
+	var twFlags uint
+	eol := "\n"
+	if layout.AlignRight {
+		// Cells must be terminated by a tab to be aligned
+		twFlags |= tabwriter.AlignRight
+		eol = "\t\n"
+	}
+	wrapHelp := func(help string) string {
+		return strings.Replace(wrapText(help, helpWidth), "\n", eol+"\t", -1) + eol
+	}
+	tw := tabwriter.NewWriter(w, layout.MinWidth, layout.TabWidth, layout.Padding, layout.PadChar, twFlags)
+	for _, v := range categorized[""] {
+		fmt.Fprintf(tw, "%s\t%s", v.Name, wrapHelp(opts.translate(v.Help)))
+	}
+	if autoHelp {
+		fmt.Fprintf(tw, "%s\t%s", helpName, wrapHelp(opts.translate("Print this help")))
+	}
+	for _, c := range categories {
+		fmt.Fprintf(tw, "\n%s:\n", opts.translate(c))
+		for _, v := range categorized[c] {
+			fmt.Fprintf(tw, "  %s\t%s", v.Name, wrapHelp(opts.translate(v.Help)))
+		}
+	}
+	tw.Flush()
+	if autoHelp {
+		fmt.Fprintf(w, "\n"+opts.translate("To view additional help for each command use %s <command_name>")+"\n", helpName)
+	}
+}
@@ -0,0 +1,150 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// CompleteCommand is the name of the hidden command used by shell
+// completion scripts to obtain the candidates for the word being
+// completed (e.g. mytool __complete build -v ma). It receives the
+// words in the command line after the tool name, with the word being
+// completed (which might be empty) as the last one, and prints the
+// candidates to stdout, one per line. Command names, flag names and
+// flag values (see Flag.Choices and Completer) are completed by the
+// package, while positional arguments are completed by calling their
+// Argument.Complete function or, when it's nil, according to their
+// Argument.Type (bool arguments complete to true, false, yes and no,
+// while file arguments complete to the existing paths). It's only
+// handled when there's no command with the same name.
+const CompleteCommand = "__complete"
+
+// complete prints the candidates for the last word in words to w.
+// See CompleteCommand.
+func complete(w io.Writer, opts *Options, words []string, commands []*Cmd) error {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	toComplete := words[len(words)-1]
+	if len(words) == 1 || (len(words) == 2 && opts.isHelpCommand(words[0])) {
+		var names []string
+		for _, v := range opts.displayedCommands(opts.listedCommands(nil, commands, false)) {
+			names = append(names, v.Name)
+		}
+		if opts.autoHelp() && len(words) == 1 {
+			names = append(names, opts.helpCommand())
+		}
+		printCandidates(w, names, toComplete)
+		return nil
+	}
+	cmd, err := resolveCommand(commands, words[0], opts != nil && opts.MatchPrefixes)
+	if err == nil && cmd == nil {
+		cmd, err = opts.resolveCommand(words[0])
+	}
+	if err != nil || cmd == nil {
+		// Nothing to complete
+		return nil
+	}
+	var flags []*Flag
+	if options := cmd.options(); len(options) > 0 {
+		if flags, err = flagsHelp(opts, options...); err != nil {
+			return err
+		}
+	}
+	byName := make(map[string]*Flag, len(flags))
+	for _, v := range flags {
+		byName[v.Name] = v
+	}
+	flagName := func(arg string) string {
+		return strings.TrimLeft(arg, "-")
+	}
+	prev := words[1 : len(words)-1]
+	if n := len(prev); n > 0 && len(prev[n-1]) > 1 && prev[n-1][0] == '-' {
+		if f := byName[flagName(prev[n-1])]; f != nil && f.Type != "bool" {
+			// Completing the value of a flag
+			printCandidates(w, append(f.Choices, f.Completions...), toComplete)
+			return nil
+		}
+	}
+	if strings.HasPrefix(toComplete, "-") {
+		var names []string
+		for _, v := range flags {
+			names = append(names, opts.flagPrefix(v.Name)+v.Name)
+		}
+		printCandidates(w, names, toComplete)
+		return nil
+	}
+	var positional []string
+	for ii := 0; ii < len(prev); ii++ {
+		arg := prev[ii]
+		if arg == "--" {
+			positional = append(positional, prev[ii+1:]...)
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' || isNegativeNumber(arg) {
+			positional = append(positional, arg)
+			continue
+		}
+		if f := byName[flagName(arg)]; f != nil && f.Type != "bool" {
+			// Skip the flag value
+			ii++
+		}
+	}
+	if pos := len(positional); pos < len(cmd.Args) {
+		if arg := cmd.Args[pos]; arg != nil {
+			if arg.Complete != nil {
+				for _, v := range arg.Complete(newArgs(positional, cmd), toComplete) {
+					fmt.Fprintln(w, v)
+				}
+				return nil
+			}
+			switch arg.Type {
+			case ArgumentBool:
+				printCandidates(w, []string{"true", "false", "yes", "no"}, toComplete)
+			case ArgumentFile:
+				printCandidates(w, fileCandidates(toComplete), toComplete)
+			}
+		}
+	}
+	return nil
+}
+
+// fileCandidates returns the paths starting with prefix, with
+// a trailing separator for directories. Hidden files are only
+// included when the prefix names them explicitly.
+func fileCandidates(prefix string) []string {
+	dir, base := filepath.Split(prefix)
+	readDir := dir
+	if readDir == "" {
+		readDir = "."
+	}
+	infos, err := ioutil.ReadDir(readDir)
+	if err != nil {
+		return nil
+	}
+	var candidates []string
+	for _, v := range infos {
+		name := v.Name()
+		if !strings.HasPrefix(name, base) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+		candidate := dir + name
+		if v.IsDir() {
+			candidate += string(filepath.Separator)
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates
+}
+
+// printCandidates prints the candidates starting with prefix to w.
+func printCandidates(w io.Writer, candidates []string, prefix string) {
+	for _, v := range candidates {
+		if strings.HasPrefix(v, prefix) {
+			fmt.Fprintln(w, v)
+		}
+	}
+}
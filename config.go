@@ -0,0 +1,130 @@
+package command
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+)
+
+// loadConfig loads the configuration file at filename using decode,
+// or as JSON if decode is nil. If the file does not exist, it returns
+// a nil map and no error.
+func loadConfig(filename string, decode func(io.Reader, interface{}) error) (map[string]interface{}, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	if decode == nil {
+		decode = decodeJSON
+	}
+	var config map[string]interface{}
+	if err := decode(f, &config); err != nil {
+		return nil, fmt.Errorf("error decoding config file %s: %v", filename, err)
+	}
+	return config, nil
+}
+
+func decodeJSON(r io.Reader, v interface{}) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// configValues returns the values which should be passed to
+// flag.Value.Set for the given configuration value. Arrays
+// produce one value per element, while objects produce
+// a key=value pair for each one of their keys. Since the
+// values might come from any ConfigDecoder, arrays and
+// objects of any type are accepted.
+func configValues(v interface{}) []string {
+	if v == nil {
+		return nil
+	}
+	val := reflect.ValueOf(v)
+	switch val.Kind() {
+	case reflect.Slice, reflect.Array:
+		var values []string
+		for ii := 0; ii < val.Len(); ii++ {
+			values = append(values, configValues(val.Index(ii).Interface())...)
+		}
+		return values
+	case reflect.Map:
+		values := make([]string, 0, val.Len())
+		for _, k := range val.MapKeys() {
+			values = append(values, fmt.Sprintf("%v=%v", k.Interface(), val.MapIndex(k).Interface()))
+		}
+		sort.Strings(values)
+		return values
+	}
+	return []string{fmt.Sprint(v)}
+}
+
+// configureOptions sets the fields in the options structs svals from
+// the values found in config, matching them by flag name, and then
+// from the environment variables, if enabled (see Options.Env), so
+// environment variables take precedence over the configuration file.
+// This must be called before the flags which are going to be parsed
+// are set up, so these values become the default ones and the command
+// line takes precedence over both of them.
+func configureOptions(opts *Options, name string, config map[string]interface{}, svals ...reflect.Value) error {
+	env := opts.envEnabled()
+	if len(config) == 0 && !env {
+		return nil
+	}
+	flags, err := setupOptionsFlags(opts, name, svals...)
+	if err != nil {
+		// Reported by the caller when setting up the flags again
+		return nil
+	}
+	var setErr error
+	flags.VisitAll(func(f *flag.Flag) {
+		if setErr != nil {
+			return
+		}
+		for _, v := range configValues(config[f.Name]) {
+			if err := f.Value.Set(v); err != nil {
+				setErr = fmt.Errorf("invalid value %q for flag -%s in config file: %v", v, f.Name, err)
+				return
+			}
+		}
+	})
+	if setErr != nil || !env {
+		return setErr
+	}
+	vars, err := envVars(opts, svals...)
+	if err != nil {
+		return err
+	}
+	for _, v := range vars {
+		if value, ok := os.LookupEnv(v.variable); ok {
+			if err := flags.Lookup(v.flag).Value.Set(value); err != nil {
+				return fmt.Errorf("invalid value %q for flag -%s in environment variable %s: %v", value, v.flag, v.variable, err)
+			}
+		}
+	}
+	return nil
+}
+
+type envVar struct {
+	flag     string
+	variable string
+}
+
+// envVars returns the environment variables for the flags
+// in the options structs svals, in the same order as the flags.
+func envVars(opts *Options, svals ...reflect.Value) ([]envVar, error) {
+	var vars []envVar
+	err := visitStructs(svals, opts.fieldName(), func(name string, help string, field *reflect.StructField, val reflect.Value, ptr interface{}) error {
+		vars = append(vars, envVar{flag: name, variable: opts.envVariable(name, field)})
+		return nil
+	})
+	return vars, err
+}
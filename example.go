@@ -0,0 +1,12 @@
+package command
+
+// Example represents an example invocation of a command,
+// shown in its help. See Cmd.Examples.
+type Example struct {
+	// Description explains what the example does. It might
+	// be empty.
+	Description string `json:"description"`
+	// Command is the full command line for the example
+	// (e.g. mytool build -v ./...), which is shown verbatim.
+	Command string `json:"command"`
+}
@@ -0,0 +1,51 @@
+package command
+
+import (
+	"reflect"
+)
+
+// FlagValues provides access to the values of the flags of a
+// command after they've been parsed, by flag name, without
+// knowing the types of its options. See Options.FlagsFunc.
+type FlagValues struct {
+	names  []string
+	values map[string]reflect.Value
+}
+
+func newFlagValues(opts *Options, svals ...reflect.Value) (*FlagValues, error) {
+	fv := &FlagValues{values: make(map[string]reflect.Value)}
+	err := visitStructs(svals, opts.fieldName(), func(name string, help string, field *reflect.StructField, val reflect.Value, ptr interface{}) error {
+		fv.names = append(fv.names, name)
+		fv.values[name] = val
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fv, nil
+}
+
+// Get returns the value of the field for the flag with the given
+// name (e.g. a bool for a bool field) and true. If there's no such
+// flag, it returns nil and false. Note that pointer fields return
+// the pointer, which is nil when the flag was not provided.
+func (f *FlagValues) Get(name string) (interface{}, bool) {
+	if f == nil {
+		return nil, false
+	}
+	val, ok := f.values[name]
+	if !ok {
+		return nil, false
+	}
+	return val.Interface(), true
+}
+
+// Names returns the names of all the flags, in the same
+// order they're declared. The automatically generated
+// negated flags for bool fields are not included.
+func (f *FlagValues) Names() []string {
+	if f == nil {
+		return nil
+	}
+	return f.names
+}
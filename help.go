@@ -1,15 +1,34 @@
 package command
 
 import (
+	"bufio"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
 	"reflect"
+	"strings"
+	"text/template"
 )
 
+var (
+	// HelpTemplateFuncs contains some functions which might be
+	// useful when writing templates for Options.HelpTemplate.
+	//
+	//  - join: joins a []string using its second argument as separator
+	//  - indent: indents every line in its second argument by the number of
+	//    spaces indicated by the first argument
+	HelpTemplateFuncs = template.FuncMap{
+		"join":   strings.Join,
+		"indent": indent,
+	}
+)
+
+func indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
 const (
 	// Setting CommandDumpHelpEnvVar to a non-empty
 	// value causes any tool using command to dump
@@ -17,15 +36,82 @@ const (
 	// it's run. It's intended to be used by 3rd party
 	// tools to automatically generate documentation
 	// for any tool using this package.
+	//
+	// If the value is command:<name>, only the help
+	// for the command with the given name is dumped
+	// (as a CommandHelp).
+	//
+	// Tools might use another variable name by setting
+	// Options.DumpHelpEnvVar.
 	CommandDumpHelpEnvVar = "COMMAND_DUMP_HELP"
+
+	dumpCommandPrefix = "command:"
+
+	// Setting CommandListEnvVar to a non-empty value
+	// causes any tool using command to print the
+	// names of its commands to the standard output,
+	// one per line, and exit without running any
+	// command. It's intended to be used by completion
+	// scripts.
+	CommandListEnvVar = "COMMAND_LIST"
 )
 
+// TypeNamer might be optionally implemented by flag.Value
+// types used in options. If implemented, TypeName is used
+// as the flag type in the help. Otherwise, flag.Value types
+// are reported as "string".
+type TypeNamer interface {
+	TypeName() string
+}
+
+// Completer might be optionally implemented by flag.Value types
+// used in options to provide the candidate values for the flag.
+// Complete returns the candidates starting with the given prefix.
+// The candidates for an empty prefix are included in the help as
+// Flag.Completions.
+type Completer interface {
+	Complete(prefix string) []string
+}
+
 // Flag represents a global or a command flag.
 type Flag struct {
-	Name    string `json:"name"`
-	Help    string `json:"help"`
-	Type    string `json:"type"`
+	Name string `json:"name"`
+	Help string `json:"help"`
+	Type string `json:"type"`
+	// Default is the value the flag takes when it's not
+	// provided in the command line, after applying the
+	// values from Options.ConfigFile and, when Options.Env
+	// is enabled, from the environment.
 	Default string `json:"default"`
+	// Choices contains the accepted values for the flag,
+	// if it has been restricted to a fixed set of them.
+	Choices []string `json:"choices"`
+	// Group is the name of the group the flag belongs
+	// to, or empty if it's not grouped.
+	Group string `json:"group"`
+	// Placeholder is the name used for the flag value in
+	// the help, as specified by its placeholder tag. If
+	// empty, Type is used.
+	Placeholder string `json:"placeholder"`
+	// Env is the environment variable which sets the flag,
+	// only when Options.Env is enabled.
+	Env string `json:"env"`
+	// Completions contains the candidate values for flags
+	// implementing Completer.
+	Completions []string `json:"completions"`
+	// Hidden is true for the flags marked as hidden.
+	// Note that they're only included in the help when
+	// Options.ShowHiddenFlags is true.
+	Hidden bool `json:"hidden"`
+}
+
+// ArgumentHelp represents the help for a command argument.
+type ArgumentHelp struct {
+	Name     string `json:"name"`
+	Help     string `json:"help"`
+	Optional bool   `json:"optional"`
+	Default  string `json:"default"`
+	Type     string `json:"type"`
 }
 
 // Help represents the help for a tool using this package.
@@ -40,24 +126,73 @@ type Help struct {
 
 // CommandHelp is the help for a given command.
 type CommandHelp struct {
-	Name     string  `json:"name"`
-	Help     string  `json:"help"`
-	LongHelp string  `json:"long_help"`
-	Usage    string  `json:"usage"`
-	Flags    []*Flag `json:"flags"`
+	Name     string          `json:"name"`
+	Help     string          `json:"help"`
+	LongHelp string          `json:"long_help"`
+	Usage    string          `json:"usage"`
+	Category string          `json:"category"`
+	Flags    []*Flag         `json:"flags"`
+	Args     []*ArgumentHelp `json:"args"`
+	// PassThrough is true when the command forwards the
+	// arguments after -- (see Cmd.PassThrough).
+	PassThrough bool `json:"pass_through"`
+	// Examples contains the examples for the command
+	// (see Cmd.Examples).
+	Examples []*Example `json:"examples"`
 }
 
-func flagsHelp(opts interface{}) ([]*Flag, error) {
-	sval := reflect.ValueOf(opts)
+// flagsHelp returns the help for the flags generated from the
+// given options, which are merged like in Cmd.SharedOptions. The
+// defaults, the configuration file and the environment variables
+// are applied to a copy of the options, so the help shows the
+// effective defaults while the values set by the caller (or by
+// parsing the flags) are left untouched.
+func flagsHelp(opts *Options, options ...interface{}) ([]*Flag, error) {
+	svals := make([]reflect.Value, len(options))
+	for ii, v := range options {
+		v = copyOptions(v)
+		setDefaults(v)
+		svals[ii] = reflect.ValueOf(v)
+	}
+	// Errors in the configuration or the environment are
+	// reported when running, so just show what could be
+	// applied here.
+	config, _ := opts.loadConfig()
+	configureOptions(opts, "", config, svals...)
 	var flags []*Flag
-	err := visitStruct(sval, func(name string, help string, field *reflect.StructField, val reflect.Value, ptr interface{}) error {
+	err := visitStructs(svals, opts.fieldName(), func(name string, help string, field *reflect.StructField, val reflect.Value, ptr interface{}) error {
 		fl := &Flag{
-			Name: name,
-			Help: help,
+			Name:        name,
+			Help:        opts.translate(help),
+			Choices:     fieldChoices(field),
+			Group:       opts.translate(field.Tag.Get("group")),
+			Hidden:      fieldHidden(field),
+			Placeholder: field.Tag.Get("placeholder"),
+		}
+		if opts.envEnabled() {
+			fl.Env = opts.envVariable(name, field)
 		}
 		if value, ok := ptr.(flag.Value); ok {
 			fl.Default = value.String()
 			fl.Type = "string"
+			if namer, ok := value.(TypeNamer); ok {
+				fl.Type = namer.TypeName()
+			}
+			if completer, ok := value.(Completer); ok {
+				fl.Completions = completer.Complete("")
+			}
+			flags = append(flags, fl)
+			return nil
+		}
+		if val.Type() == timeType {
+			fl.Default = formatTime(val, fieldLayout(field))
+			fl.Type = "time"
+			flags = append(flags, fl)
+			return nil
+		}
+		if val.Type() == urlType {
+			fl.Default = formatURL(val)
+			fl.Type = "url"
 			flags = append(flags, fl)
 			return nil
 		}
@@ -65,6 +200,23 @@ func flagsHelp(opts interface{}) ([]*Flag, error) {
 		case reflect.Bool, reflect.Float64, reflect.Int, reflect.Uint, reflect.Int64, reflect.Uint64, reflect.String:
 			fl.Default = fmt.Sprintf("%v", val.Interface())
 			fl.Type = fmt.Sprintf("%s", val.Type())
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+			fl.Default = fmt.Sprintf("%v", val.Interface())
+			fl.Type = val.Kind().String()
+		case reflect.Map:
+			if !isStringMap(val.Type()) {
+				return fmt.Errorf("field %s has invalid option type %s", field.Name, field.Type)
+			}
+			fl.Default = formatMap(val)
+			fl.Type = "map"
+		case reflect.Ptr:
+			if !isBasicKind(val.Type().Elem().Kind()) {
+				return fmt.Errorf("field %s has invalid option type %s", field.Name, field.Type)
+			}
+			if !val.IsNil() {
+				fl.Default = fmt.Sprintf("%v", val.Elem().Interface())
+			}
+			fl.Type = fmt.Sprintf("%s", val.Type().Elem())
 		default:
 			return fmt.Errorf("field %s has invalid option type %s", field.Name, field.Type)
 		}
@@ -74,43 +226,250 @@ func flagsHelp(opts interface{}) ([]*Flag, error) {
 	if err != nil {
 		return nil, err
 	}
+	if opts == nil || !opts.ShowHiddenFlags {
+		visible := flags[:0]
+		for _, v := range flags {
+			if !v.Hidden {
+				visible = append(visible, v)
+			}
+		}
+		flags = visible
+	}
 	return flags, nil
 }
 
-func commandHelp(cmd *Cmd) (*CommandHelp, error) {
+func (f *Flag) hasDefault() bool {
+	switch f.Default {
+	case "", "0", "false":
+		return false
+	}
+	return true
+}
+
+func printFlag(w io.Writer, opts *Options, f *Flag) {
+	width := opts.helpWidth()
+	fmt.Fprintf(w, "  %s%s", opts.flagPrefix(f.Name), f.Name)
+	if f.Placeholder != "" {
+		fmt.Fprintf(w, " %s", f.Placeholder)
+	} else if f.Type != "bool" {
+		fmt.Fprintf(w, " %s", f.Type)
+	}
+	fmt.Fprint(w, "\n    \t")
+	fmt.Fprint(w, strings.Replace(wrapText(f.Help, width-flagHelpIndent), "\n", "\n    \t", -1))
+	if len(f.Choices) > 0 {
+		fmt.Fprintf(w, " (%s %s)", opts.translate("one of"), strings.Join(f.Choices, ", "))
+	}
+	if f.hasDefault() {
+		if f.Type == "string" {
+			fmt.Fprintf(w, " (%s %q)", opts.translate("default"), f.Default)
+		} else {
+			fmt.Fprintf(w, " (%s %s)", opts.translate("default"), f.Default)
+		}
+	}
+	if f.Env != "" {
+		fmt.Fprintf(w, " (%s $%s)", opts.translate("env"), f.Env)
+	}
+	fmt.Fprint(w, "\n")
+}
+
+// printFlags prints the given flags in the same order they were
+// declared. Flags without a group are printed first, under the given
+// title, while grouped flags are printed under the group name, with
+// groups sorted by their first appearance. The help for each flag is
+// wrapped to the width given by opts.
+func printFlags(w io.Writer, opts *Options, title string, flags []*Flag) {
+	var groups []string
+	grouped := make(map[string][]*Flag)
+	for _, v := range flags {
+		if _, found := grouped[v.Group]; !found && v.Group != "" {
+			groups = append(groups, v.Group)
+		}
+		grouped[v.Group] = append(grouped[v.Group], v)
+	}
+	if ungrouped := grouped[""]; len(ungrouped) > 0 {
+		fmt.Fprintf(w, "\n%s:\n", opts.translate(title))
+		for _, v := range ungrouped {
+			printFlag(w, opts, v)
+		}
+	}
+	for _, g := range groups {
+		fmt.Fprintf(w, "\n%s:\n", g)
+		for _, v := range grouped[g] {
+			printFlag(w, opts, v)
+		}
+	}
+}
+
+func commandHelp(opts *Options, cmd *Cmd) (*CommandHelp, error) {
 	h := &CommandHelp{
-		Name:     cmd.Name,
-		Help:     cmd.Help,
-		LongHelp: cmd.LongHelp,
-		Usage:    cmd.Usage,
+		Name:        cmd.Name,
+		Help:        opts.translate(cmd.Help),
+		LongHelp:    opts.translate(cmd.LongHelp),
+		Usage:       opts.translate(cmd.Usage),
+		Category:    opts.translate(cmd.Category),
+		PassThrough: cmd.PassThrough,
 	}
-	if cmd.Options != nil {
-		flags, err := flagsHelp(cmd.Options)
+	if options := cmd.options(); len(options) > 0 {
+		flags, err := flagsHelp(opts, options...)
 		if err != nil {
 			return nil, err
 		}
 		h.Flags = flags
 	}
+	for _, v := range cmd.Examples {
+		h.Examples = append(h.Examples, &Example{
+			Description: opts.translate(v.Description),
+			Command:     v.Command,
+		})
+	}
+	for _, v := range cmd.Args {
+		if v != nil {
+			h.Args = append(h.Args, &ArgumentHelp{
+				Name:     v.Name,
+				Help:     opts.translate(v.Help),
+				Optional: v.Optional,
+				Default:  v.Default,
+				Type:     v.Type,
+			})
+		}
+	}
 	return h, nil
 }
 
-func dumpHelp(w io.Writer, opts *Options, commands []*Cmd) error {
+// Describe returns the help information for the given commands and
+// options, the same one that is dumped as JSON when the environment
+// variable named by CommandDumpHelpEnvVar is set. This allows
+// rendering the help for the commands in other ways, without
+// requiring the environment variable to be set.
+func Describe(commands []*Cmd, opts *Options) (*Help, error) {
 	help := &Help{
-		Name: filepath.Base(os.Args[0]),
+		Name: opts.name(),
 	}
 	if opts != nil && opts.Options != nil {
-		flags, err := flagsHelp(opts.Options)
+		flags, err := flagsHelp(opts, opts.Options)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		help.Flags = flags
 	}
-	for _, v := range commands {
-		cmd, err := commandHelp(v)
+	for _, v := range opts.displayedCommands(commands) {
+		cmd, err := commandHelp(opts, v)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		help.Commands = append(help.Commands, cmd)
 	}
+	return help, nil
+}
+
+// DescribeCommand returns the help information for a single command,
+// the same one included in the Help returned by Describe.
+func DescribeCommand(cmd *Cmd, opts *Options) (*CommandHelp, error) {
+	return commandHelp(opts, cmd)
+}
+
+// dumpHelp encodes the help for the tool to w as JSON. If value
+// starts with dumpCommandPrefix, only the help for the command named
+// by the rest of value is encoded, returning an UnknownCommandError
+// if there's no such command.
+func dumpHelp(w io.Writer, opts *Options, commands []*Cmd, value string) error {
+	var help interface{}
+	var err error
+	if strings.HasPrefix(value, dumpCommandPrefix) {
+		name := value[len(dumpCommandPrefix):]
+		cmd := commandByName(commands, name)
+		if cmd == nil {
+			return UnknownCommandError(name)
+		}
+		help, err = DescribeCommand(cmd, opts)
+	} else {
+		return WriteHelp(w, commands, opts)
+	}
+	if err != nil {
+		return err
+	}
 	return json.NewEncoder(w).Encode(help)
 }
+
+// WriteHelp writes the help for the given commands and options to w
+// as JSON, producing the same output as encoding the *Help returned
+// by Describe with a json.Encoder. However, the help for each command
+// is encoded as soon as it's built, rather than accumulating all of
+// them in memory first, so it's better suited for tools with lots of
+// commands. This is the function used for dumping the help when the
+// environment variable named by CommandDumpHelpEnvVar is set.
+func WriteHelp(w io.Writer, commands []*Cmd, opts *Options) error {
+	var flags []*Flag
+	if opts != nil && opts.Options != nil {
+		var err error
+		if flags, err = flagsHelp(opts, opts.Options); err != nil {
+			return err
+		}
+	}
+	bw := bufio.NewWriter(w)
+	encode := func(prefix string, v interface{}) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		bw.WriteString(prefix)
+		bw.Write(data)
+		return nil
+	}
+	if err := encode(`{"name":`, opts.name()); err != nil {
+		return err
+	}
+	if err := encode(`,"flags":`, flags); err != nil {
+		return err
+	}
+	commands = opts.displayedCommands(commands)
+	if len(commands) == 0 {
+		bw.WriteString(`,"commands":null`)
+	} else {
+		sep := `,"commands":[`
+		for _, v := range commands {
+			cmd, err := commandHelp(opts, v)
+			if err != nil {
+				return err
+			}
+			if err := encode(sep, cmd); err != nil {
+				return err
+			}
+			sep = ","
+		}
+		bw.WriteString("]")
+	}
+	bw.WriteString("}\n")
+	// bufio.Writer keeps the first error, so checking
+	// the one returned by Flush is enough.
+	return bw.Flush()
+}
+
+// printCommandNames prints the names of all the available commands,
+// one per line, including the ones provided by Options.Options.
+func printCommandNames(w io.Writer, opts *Options, commands []*Cmd) {
+	all := append(append([]*Cmd(nil), commands...), opts.additionalCommands()...)
+	for _, v := range opts.displayedCommands(opts.listedCommands(nil, all, false)) {
+		fmt.Fprintln(w, v.Name)
+	}
+	if opts.autoHelp() && commandByName(all, opts.helpCommand()) == nil {
+		fmt.Fprintln(w, opts.helpCommand())
+	}
+}
+
+// executeHelpTemplate executes the template with the given name from
+// Options.HelpTemplate, if it's defined. It returns true if the
+// template was executed.
+func executeHelpTemplate(w io.Writer, opts *Options, name string, data interface{}) bool {
+	if opts == nil || opts.HelpTemplate == nil {
+		return false
+	}
+	tmpl := opts.HelpTemplate.Lookup(name)
+	if tmpl == nil {
+		return false
+	}
+	if err := tmpl.Execute(w, data); err != nil {
+		panic(fmt.Errorf("error executing help template %s: %v", name, err))
+	}
+	return true
+}
@@ -0,0 +1,55 @@
+package command
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+)
+
+const (
+	// DumpInvocationFlag is the name of the global flag enabled
+	// by Options.DumpInvocation.
+	DumpInvocationFlag = "dump-invocation"
+)
+
+// invocation represents a resolved command invocation, dumped
+// as JSON when Options.DumpInvocation is enabled and the user
+// provides the DumpInvocationFlag.
+type invocation struct {
+	Command     string            `json:"command"`
+	GlobalFlags map[string]string `json:"global_flags"`
+	Flags       map[string]string `json:"flags"`
+	Args        []string          `json:"args"`
+}
+
+// flagValues returns the current values of all the flags in the
+// given set, omitting the automatically generated negated flags.
+func flagValues(flags *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+	flags.VisitAll(func(f *flag.Flag) {
+		if _, ok := f.Value.(*negatedBoolValue); ok || f.Name == DumpInvocationFlag {
+			return
+		}
+		values[f.Name] = f.Value.String()
+	})
+	return values
+}
+
+// stripDumpInvocation removes any leading DumpInvocationFlag from
+// args, returning the remaining ones and whether it was found. It's
+// used when there are no global options, so no flag.FlagSet is
+// involved in parsing them.
+func stripDumpInvocation(args []string) ([]string, bool) {
+	found := false
+	for len(args) > 0 && (args[0] == "-"+DumpInvocationFlag || args[0] == "--"+DumpInvocationFlag) {
+		found = true
+		args = args[1:]
+	}
+	return args, found
+}
+
+func (inv *invocation) dump(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(inv)
+}
@@ -0,0 +1,148 @@
+package command
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// CmdOption represents an option for configuring a
+// Cmd created by New.
+type CmdOption func(*Cmd)
+
+// WithHelp sets Cmd.Help.
+func WithHelp(help string) CmdOption {
+	return func(c *Cmd) { c.Help = help }
+}
+
+// WithLongHelp sets Cmd.LongHelp.
+func WithLongHelp(longHelp string) CmdOption {
+	return func(c *Cmd) { c.LongHelp = longHelp }
+}
+
+// WithUsage sets Cmd.Usage.
+func WithUsage(usage string) CmdOption {
+	return func(c *Cmd) { c.Usage = usage }
+}
+
+// WithCategory sets Cmd.Category.
+func WithCategory(category string) CmdOption {
+	return func(c *Cmd) { c.Category = category }
+}
+
+// WithOptions sets Cmd.Options.
+func WithOptions(options interface{}) CmdOption {
+	return func(c *Cmd) { c.Options = options }
+}
+
+// WithArgs sets Cmd.Args.
+func WithArgs(args ...*Argument) CmdOption {
+	return func(c *Cmd) { c.Args = args }
+}
+
+// WithSharedOptions sets Cmd.SharedOptions.
+func WithSharedOptions(options ...interface{}) CmdOption {
+	return func(c *Cmd) { c.SharedOptions = options }
+}
+
+// WithExamples sets Cmd.Examples.
+func WithExamples(examples ...*Example) CmdOption {
+	return func(c *Cmd) { c.Examples = examples }
+}
+
+// WithGlobalOptions declares that the command handler receives the
+// global options, which must be a pointer to a struct of the same type
+// as the ones in Options.Options, so New can check the handler. Only
+// the type of options is used.
+func WithGlobalOptions(options interface{}) CmdOption {
+	return func(c *Cmd) { c.globalOptions = options }
+}
+
+// WithBeforeFunc sets Cmd.BeforeFunc.
+func WithBeforeFunc(fn func(*Args) error) CmdOption {
+	return func(c *Cmd) { c.BeforeFunc = fn }
+}
+
+// WithAfterFunc sets Cmd.AfterFunc.
+func WithAfterFunc(fn func(*Args, error) error) CmdOption {
+	return func(c *Cmd) { c.AfterFunc = fn }
+}
+
+// New returns a new Cmd with the given name and handler, configured
+// by the given options. Unlike a Cmd declared as a struct literal,
+// whose handler is only checked when the command is run, New panics
+// if fn doesn't have a valid signature for a handler (see Cmd.Func),
+// if the command options can't be represented as flags or if a required
+// argument is declared after an optional one. This way, these mistakes
+// are caught when the program starts.
+//
+// Handlers receiving the global options must declare their type with
+// WithGlobalOptions. Otherwise, since the global options are not known
+// when calling New, a handler receiving an additional struct (or pointer
+// to a struct) after the command options is assumed to receive them, and
+// their type is only checked when the command runs. Handlers for commands
+// without options which receive a struct must always use either
+// WithOptions or WithGlobalOptions.
+func New(name string, fn interface{}, opts ...CmdOption) *Cmd {
+	cmd := &Cmd{Name: name, Func: fn}
+	for _, v := range opts {
+		v(cmd)
+	}
+	if err := cmd.checkArgs(); err != nil {
+		panic(fmt.Errorf("command %s: %v", name, err))
+	}
+	if options := cmd.options(); len(options) > 0 {
+		if errs := validateOptions(nil, name, options...); len(errs) > 0 {
+			panic(&ValidationError{Errors: errs})
+		}
+	}
+	if fn == nil {
+		return cmd
+	}
+	val := reflect.ValueOf(fn)
+	if val.Kind() != reflect.Func {
+		panic(fmt.Errorf("command handler %s is not a function, it's %T", name, fn))
+	}
+	if err := validateCmdFuncReturn(val); err != nil {
+		panic(fmt.Errorf("invalid handler for command %q: %s", name, err))
+	}
+	var optsVal reflect.Value
+	var globalVal reflect.Value
+	if cmd.Options != nil {
+		optsVal = reflect.ValueOf(cmd.Options)
+	}
+	switch {
+	case cmd.globalOptions != nil:
+		globalVal = reflect.ValueOf(cmd.globalOptions)
+		if globalVal.Kind() != reflect.Ptr || globalVal.Elem().Kind() != reflect.Struct {
+			panic(fmt.Errorf("command %s: global options must be a pointer to a struct, not %T", name, cmd.globalOptions))
+		}
+	case optsVal.IsValid():
+		globalVal = globalOptionsArg(val.Type())
+	default:
+		if arg := globalOptionsArg(val.Type()); arg.IsValid() {
+			panic(fmt.Errorf("invalid handler for command %q: it receives %s, but the command has no options (see WithOptions and WithGlobalOptions)", name, val.Type().In(val.Type().NumIn()-1)))
+		}
+	}
+	if err := validateCmdFuncInput(val, optsVal, globalVal); err != nil {
+		panic(fmt.Errorf("invalid handler for command %q: %s", name, err))
+	}
+	return cmd
+}
+
+// globalOptionsArg returns a value with the type of the last
+// argument received by the handler type fnTyp, as long as it
+// might be the global options. Otherwise, it returns an
+// invalid reflect.Value.
+func globalOptionsArg(fnTyp reflect.Type) reflect.Value {
+	if fnTyp.NumIn() == 0 {
+		return reflect.Value{}
+	}
+	typ := fnTyp.In(fnTyp.NumIn() - 1)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct || typ == reflect.TypeOf(Args{}) {
+		return reflect.Value{}
+	}
+	return reflect.New(typ)
+}
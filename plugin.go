@@ -0,0 +1,223 @@
+package command
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// PluginHelpArg is the argument passed to plugins by Plugins
+	// to obtain their short help. Plugins should print a single line
+	// with their help and exit with status zero when they receive it
+	// as their only argument.
+	PluginHelpArg = "--command-plugin-help"
+	// DefaultPluginHelpTimeout is the maximum time a plugin might
+	// take to print its help when Plugins.HelpTimeout is zero.
+	DefaultPluginHelpTimeout = time.Second
+)
+
+// Plugins provides a command for every executable file in Dir whose
+// name starts with Prefix, similarly to how git discovers its
+// subcommands. The command name is the file name without the prefix
+// (e.g. with prefix "mytool-", an executable named mytool-deploy
+// becomes the deploy command). When any of these commands is run, the
+// plugin is executed with all the command arguments (including -h,
+// see Cmd.RawArgs), sharing the standard input, output and error of
+// the current process. If the plugin fails, the returned error is an
+// *exec.ExitError, so Exit uses the same exit status as the plugin.
+// If Dir does not exist, no commands are provided.
+//
+// Plugins is intended to be used from the methods of the type in
+// Options.Options. It implements CommandProvider, but since Commands
+// runs every plugin to obtain its help (see PluginHelpArg) every time
+// the program runs, implementing CommandResolver and CommandHelper is
+// usually preferred. That way, plugins are only executed when their
+// command is run or when the help is displayed.
+type Plugins struct {
+	// Dir is the directory containing the plugins.
+	Dir string
+	// Prefix is the prefix for the plugin file names.
+	Prefix string
+	// HelpTimeout is the maximum time a plugin might take to print
+	// its help. If zero, DefaultPluginHelpTimeout is used.
+	HelpTimeout time.Duration
+}
+
+// Commands implements CommandProvider, returning a command for every
+// plugin, with its help. The plugins are run concurrently to obtain it.
+func (p *Plugins) Commands() ([]*Cmd, error) {
+	names, err := p.names()
+	if err != nil {
+		return nil, err
+	}
+	cmds := make([]*Cmd, 0, len(names))
+	var wg sync.WaitGroup
+	for _, v := range names {
+		path, err := p.path(v)
+		if err != nil {
+			return nil, err
+		}
+		if path == "" {
+			// Removed after listing the directory
+			continue
+		}
+		cmd := pluginCommand(v, path)
+		cmds = append(cmds, cmd)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd.Help = pluginHelp(path, p.helpTimeout())
+		}()
+	}
+	wg.Wait()
+	return cmds, nil
+}
+
+// ResolveCommand implements CommandResolver.
+func (p *Plugins) ResolveCommand(name string) (*Cmd, error) {
+	path, err := p.path(name)
+	if err != nil || path == "" {
+		return nil, err
+	}
+	return pluginCommand(name, path), nil
+}
+
+// CommandNames implements CommandResolver.
+func (p *Plugins) CommandNames() []string {
+	names, _ := p.names()
+	return names
+}
+
+// names returns the names of the commands provided by the plugins.
+func (p *Plugins) names() ([]string, error) {
+	infos, err := ioutil.ReadDir(p.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, v := range infos {
+		name := v.Name()
+		if !strings.HasPrefix(name, p.Prefix) || !isExecutable(v) {
+			continue
+		}
+		if name = strings.TrimSuffix(name[len(p.Prefix):], exeSuffix()); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// CommandHelp implements CommandHelper, by running the plugin for the
+// command with PluginHelpArg. If it fails or it doesn't finish within
+// the help timeout, an empty string is returned.
+func (p *Plugins) CommandHelp(name string) string {
+	path, err := p.path(name)
+	if err != nil || path == "" {
+		return ""
+	}
+	return pluginHelp(path, p.helpTimeout())
+}
+
+func (p *Plugins) helpTimeout() time.Duration {
+	if p.HelpTimeout > 0 {
+		return p.HelpTimeout
+	}
+	return DefaultPluginHelpTimeout
+}
+
+// path returns the path to the plugin for the command with the
+// given name, or an empty string if there's no such plugin.
+func (p *Plugins) path(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return "", nil
+	}
+	path := filepath.Join(p.Dir, p.Prefix+name+exeSuffix())
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if !isExecutable(info) {
+		return "", nil
+	}
+	return path, nil
+}
+
+func pluginCommand(name string, path string) *Cmd {
+	return &Cmd{
+		Name:    name,
+		RawArgs: true,
+		Func: func(args *Args) error {
+			cmd := exec.Command(path, args.Args()...)
+			cmd.Stdin = os.Stdin
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			return cmd.Run()
+		},
+	}
+}
+
+// pluginHelp returns the first line printed by the plugin at path
+// when run with PluginHelpArg, or an empty string if it fails or
+// it takes longer than timeout.
+func pluginHelp(path string, timeout time.Duration) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return ""
+	}
+	defer r.Close()
+	cmd := exec.Command(path, PluginHelpArg)
+	// Pass the pipe directly rather than letting exec copy the
+	// output, so waiting for the plugin doesn't also wait for
+	// any processes it started which still hold the pipe open.
+	cmd.Stdout = w
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return ""
+	}
+	done := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(r).ReadString('\n')
+		if cmd.Wait() != nil {
+			line = ""
+		}
+		done <- strings.TrimSpace(line)
+	}()
+	select {
+	case help := <-done:
+		return help
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		return ""
+	}
+}
+
+func isExecutable(info os.FileInfo) bool {
+	if !info.Mode().IsRegular() {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(filepath.Ext(info.Name()), ".exe")
+	}
+	return info.Mode()&0111 != 0
+}
+
+func exeSuffix() string {
+	if runtime.GOOS == "windows" {
+		return ".exe"
+	}
+	return ""
+}
@@ -0,0 +1,298 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+func isBoolFlag(f *flag.Flag) bool {
+	if bf, ok := f.Value.(interface {
+		IsBoolFlag() bool
+	}); ok {
+		return bf.IsBoolFlag()
+	}
+	return false
+}
+
+// expandFlagClusters expands clusters of single letter flags (e.g.
+// -abc) into individual flags (-a -b -c), as long as all the letters
+// are bool flags defined in flags. A non-bool flag might appear at the
+// end of the cluster, using the rest of the cluster as its value (e.g.
+// -vn5 is expanded to -v -n=5). Arguments after the first non-flag
+// argument are left untouched, since the flag package stops parsing there.
+func expandFlagClusters(flags *flag.FlagSet, args []string) []string {
+	var expanded []string
+	for ii := 0; ii < len(args); ii++ {
+		arg := args[ii]
+		if len(arg) < 2 || arg[0] != '-' || arg == "--" {
+			// First non-flag argument or terminator
+			return append(expanded, args[ii:]...)
+		}
+		name := arg[1:]
+		if name[0] == '-' || strings.IndexByte(name, '=') >= 0 {
+			// --flag or -flag=value, never a cluster
+			expanded = append(expanded, arg)
+			continue
+		}
+		if f := flags.Lookup(name); f != nil {
+			expanded = append(expanded, arg)
+			if !isBoolFlag(f) && ii+1 < len(args) {
+				// Next argument is the value
+				ii++
+				expanded = append(expanded, args[ii])
+			}
+			continue
+		}
+		cluster, needsValue, ok := expandCluster(flags, name)
+		if !ok {
+			// Let the flag package report the error
+			expanded = append(expanded, arg)
+			continue
+		}
+		expanded = append(expanded, cluster...)
+		if needsValue && ii+1 < len(args) {
+			ii++
+			expanded = append(expanded, args[ii])
+		}
+	}
+	return expanded
+}
+
+// expandCluster expands a single cluster without the leading dash.
+// It returns the expanded flags, whether the last flag needs its value
+// from the next argument and whether the cluster could be expanded.
+func expandCluster(flags *flag.FlagSet, cluster string) ([]string, bool, bool) {
+	var expanded []string
+	for ii, c := range cluster {
+		f := flags.Lookup(string(c))
+		if f == nil {
+			return nil, false, false
+		}
+		if isBoolFlag(f) {
+			expanded = append(expanded, "-"+f.Name)
+			continue
+		}
+		if rem := cluster[ii+len(string(c)):]; rem != "" {
+			return append(expanded, "-"+f.Name+"="+rem), false, true
+		}
+		return append(expanded, "-"+f.Name), true, true
+	}
+	return expanded, false, true
+}
+
+// flagTakesValue returns true iff the given flag argument is a
+// non-bool flag without an inline value, so it takes its value
+// from the next argument. If clusters is true, arg might also be
+// a cluster of single letter flags.
+func flagTakesValue(flags *flag.FlagSet, arg string, clusters bool) bool {
+	name := arg[1:]
+	if name != "" && name[0] == '-' {
+		name = name[1:]
+		clusters = false
+	}
+	if name == "" || strings.IndexByte(name, '=') >= 0 {
+		return false
+	}
+	if f := flags.Lookup(name); f != nil {
+		return !isBoolFlag(f)
+	}
+	if clusters {
+		_, needsValue, ok := expandCluster(flags, name)
+		return ok && needsValue
+	}
+	return false
+}
+
+// reorderFlags moves all the flags in args (and their values)
+// before the first positional argument, so the flag package
+// parses flags found after positional arguments too. Arguments
+// after -- are never reordered. If clusters is true, clusters
+// of single letter flags are recognized too.
+func reorderFlags(flags *flag.FlagSet, args []string, clusters bool) []string {
+	var flagArgs []string
+	var positional []string
+	for ii := 0; ii < len(args); ii++ {
+		arg := args[ii]
+		if arg == "--" {
+			positional = append(positional, args[ii:]...)
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' || isNumberArg(flags, arg, clusters) {
+			positional = append(positional, arg)
+			continue
+		}
+		flagArgs = append(flagArgs, arg)
+		if flagTakesValue(flags, arg, clusters) && ii+1 < len(args) {
+			ii++
+			flagArgs = append(flagArgs, args[ii])
+		}
+	}
+	return append(flagArgs, positional...)
+}
+
+// isNegativeNumber returns true iff arg looks like a
+// negative number (e.g. -5 or -2.5).
+func isNegativeNumber(arg string) bool {
+	if len(arg) < 2 || arg[0] != '-' {
+		return false
+	}
+	dot := false
+	for ii := 1; ii < len(arg); ii++ {
+		c := arg[ii]
+		switch {
+		case c >= '0' && c <= '9':
+		case c == '.' && !dot && ii > 1 && ii < len(arg)-1:
+			dot = true
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isNumberArg returns true iff arg is a negative number which
+// doesn't name any flag in flags (nor a cluster of flags, when
+// clusters is true), so it must be a positional argument.
+func isNumberArg(flags *flag.FlagSet, arg string, clusters bool) bool {
+	if !isNegativeNumber(arg) || flags.Lookup(arg[1:]) != nil {
+		return false
+	}
+	if clusters {
+		if _, _, ok := expandCluster(flags, arg[1:]); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// splitNumberArgs splits args at the first negative number which
+// is not a flag nor a flag value, since the flag package would
+// otherwise report it as an unknown flag. It returns the arguments
+// to be parsed as flags and the positional arguments starting at
+// the number, if any.
+func splitNumberArgs(flags *flag.FlagSet, args []string, clusters bool) ([]string, []string) {
+	for ii := 0; ii < len(args); ii++ {
+		arg := args[ii]
+		if len(arg) < 2 || arg[0] != '-' || arg == "--" {
+			break
+		}
+		if isNumberArg(flags, arg, clusters) {
+			return args[:ii], args[ii:]
+		}
+		if flagTakesValue(flags, arg, clusters) {
+			ii++
+		}
+	}
+	return args, nil
+}
+
+// consumesSeparator returns true iff the flag package stops
+// parsing args at a -- separator, consuming it. The values of
+// the flags are skipped, so a flag value of -- (e.g. -sep --)
+// is not mistaken for the separator.
+func consumesSeparator(flags *flag.FlagSet, args []string) bool {
+	for ii := 0; ii < len(args); ii++ {
+		arg := args[ii]
+		if arg == "--" {
+			return true
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			return false
+		}
+		if flagTakesValue(flags, arg, false) {
+			ii++
+		}
+	}
+	return false
+}
+
+// gnuFlagArgs rewrites args following the GNU conventions into the
+// form expected by the flag package. Flags with a name longer than
+// one letter must be specified as --name, --name=value or --name
+// value, while single letter flags use a single dash and might be
+// clustered (see expandFlagClusters). Specifying a long flag with
+// a single dash is an error. Arguments after the first non-flag
+// argument are left untouched.
+func gnuFlagArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var canonical []string
+	for ii := 0; ii < len(args); ii++ {
+		arg := args[ii]
+		if len(arg) < 2 || arg[0] != '-' || arg == "--" || isNumberArg(flags, arg, true) {
+			// First non-flag argument or terminator
+			return append(canonical, args[ii:]...), nil
+		}
+		if arg[1] == '-' {
+			// --name or --name=value
+			canonical = append(canonical, arg[1:])
+			if flagTakesValue(flags, arg, false) && ii+1 < len(args) {
+				ii++
+				canonical = append(canonical, args[ii])
+			}
+			continue
+		}
+		name := arg[1:]
+		hasValue := false
+		if p := strings.IndexByte(name, '='); p >= 0 {
+			name = name[:p]
+			hasValue = true
+		}
+		if utf8.RuneCountInString(name) > 1 {
+			if !hasValue {
+				if cluster, needsValue, ok := expandCluster(flags, name); ok {
+					canonical = append(canonical, cluster...)
+					if needsValue && ii+1 < len(args) {
+						ii++
+						canonical = append(canonical, args[ii])
+					}
+					continue
+				}
+			}
+			if flags.Lookup(name) != nil {
+				return nil, fmt.Errorf("flag -%s must be specified as --%s", name, name)
+			}
+			// Let the flag package report the error
+			canonical = append(canonical, arg)
+			continue
+		}
+		canonical = append(canonical, arg)
+		if flagTakesValue(flags, arg, false) && ii+1 < len(args) {
+			ii++
+			canonical = append(canonical, args[ii])
+		}
+	}
+	return canonical, nil
+}
+
+// checkFlagOrder returns an error if any of the positional arguments
+// in args (after the flags and up to the first --) looks like a flag.
+func checkFlagOrder(flags *flag.FlagSet, args []string) error {
+	ii := 0
+	// Skip the flags, like the flag package does
+	for ; ii < len(args); ii++ {
+		arg := args[ii]
+		if arg == "--" {
+			return nil
+		}
+		if len(arg) < 2 || arg[0] != '-' || isNumberArg(flags, arg, false) {
+			break
+		}
+		if flagTakesValue(flags, arg, false) {
+			ii++
+		}
+	}
+	for ; ii < len(args); ii++ {
+		arg := args[ii]
+		if arg == "--" {
+			break
+		}
+		if len(arg) > 1 && arg[0] == '-' && !isNegativeNumber(arg) {
+			if p := strings.IndexByte(arg, '='); p >= 0 {
+				arg = arg[:p]
+			}
+			return fmt.Errorf("flag %s must come before positional arguments", arg)
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package command
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"runtime"
 	"strings"
@@ -11,15 +12,22 @@ const (
 	// When this environment variable is non-empty, command won't
 	// recover from a panic during a command, producing the full
 	// stack trace. Useful for debugging problems with commands.
+	// See also Options.NoRecover.
 	LetCommandPanic = "LET_COMMAND_PANIC"
 )
 
-func recoverRun(cmd *Cmd, err *error) {
+func recoverRun(w io.Writer, opts *Options, cmd *Cmd, err *error) {
 	if os.Getenv(LetCommandPanic) != "" {
 		// Let it panic and generate the full stack trace
 		return
 	}
 	if r := recover(); r != nil {
+		if opts != nil && opts.PanicHandler != nil {
+			opts.PanicHandler(cmd, r, stack())
+		}
+		if opts != nil && opts.NoRecover {
+			panic(r)
+		}
 		var file string
 		var line int
 		skip, _, _, ok := getPanic()
@@ -32,8 +40,20 @@ func recoverRun(cmd *Cmd, err *error) {
 			*err = fmt.Errorf("panic running command %s: %v", cmd.Name, r)
 		}
 		if err != nil && *err != nil {
-			fmt.Fprintf(os.Stderr, "%s\n", *err)
+			fmt.Fprintf(w, "%s\n", *err)
+		}
+	}
+}
+
+// stack returns the full stack trace for the current goroutine.
+func stack() []byte {
+	buf := make([]byte, 4096)
+	for {
+		n := runtime.Stack(buf, false)
+		if n < len(buf) {
+			return buf[:n]
 		}
+		buf = make([]byte, len(buf)*2)
 	}
 }
 
@@ -0,0 +1,52 @@
+package command
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Registry holds a set of commands, allowing them to be registered
+// from several places (e.g. init functions in different files) rather
+// than assembling them into a slice. The zero Registry is ready to use.
+type Registry struct {
+	mu       sync.Mutex
+	commands []*Cmd
+}
+
+// DefaultRegistry is the Registry used by the Register function.
+var DefaultRegistry = &Registry{}
+
+// Register adds the given command to the registry. Note that Register
+// panics if there's already a registered command with the same name.
+func (r *Registry) Register(cmd *Cmd) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if commandByName(r.commands, cmd.Name) != nil {
+		panic(fmt.Errorf("duplicate command %s", cmd.Name))
+	}
+	r.commands = append(r.commands, cmd)
+}
+
+// Commands returns the registered commands, in the same order
+// they were registered.
+func (r *Registry) Commands() []*Cmd {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*Cmd(nil), r.commands...)
+}
+
+// Run is a shorthand for RunOpts(nil, nil).
+func (r *Registry) Run() error {
+	return r.RunOpts(nil, nil)
+}
+
+// RunOpts works like the RunOpts function, using the registered
+// commands.
+func (r *Registry) RunOpts(args []string, opts *Options) error {
+	return RunOpts(args, opts, r.Commands())
+}
+
+// Register is a shorthand for DefaultRegistry.Register(cmd).
+func Register(cmd *Cmd) {
+	DefaultRegistry.Register(cmd)
+}
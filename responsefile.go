@@ -0,0 +1,54 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// expandResponseFiles replaces every argument starting with @ in args
+// (up to the first --) with the arguments read from the file named by
+// the rest of the argument. Response files contain one argument per
+// line, with leading and trailing whitespace removed and empty lines
+// skipped. Arguments starting with @@ are replaced by the same argument
+// minus its first @. Response files are not expanded recursively.
+func expandResponseFiles(args []string) ([]string, error) {
+	var expanded []string
+	for ii, v := range args {
+		switch {
+		case v == "--":
+			return append(expanded, args[ii:]...), nil
+		case strings.HasPrefix(v, "@@"):
+			expanded = append(expanded, v[1:])
+		case len(v) > 1 && v[0] == '@':
+			lines, err := readResponseFile(v[1:])
+			if err != nil {
+				return nil, err
+			}
+			expanded = append(expanded, lines...)
+		default:
+			expanded = append(expanded, v)
+		}
+	}
+	return expanded, nil
+}
+
+func readResponseFile(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response file: %v", err)
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading response file %s: %v", filename, err)
+	}
+	return lines, nil
+}
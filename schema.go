@@ -0,0 +1,105 @@
+package command
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// OptionsSchema returns a JSON Schema describing the flags generated for
+// the given options, which must follow the same rules as Cmd.Options. The
+// returned object has a property for each flag (hidden ones included),
+// with its type, help, default value, choices (as "enum") and the
+// variable from its env tag, if any. Since flags are always optional,
+// the schema has no required properties. It's intended to be
+// encoded as JSON by tools building on top of the command line interface
+// (e.g. form generators).
+func OptionsSchema(options interface{}) (map[string]interface{}, error) {
+	flags, err := flagsHelp(&Options{ShowHiddenFlags: true}, options)
+	if err != nil {
+		return nil, err
+	}
+	// Explicit env tags are included even when Options.Env is
+	// disabled, since they're part of the field's metadata.
+	env := make(map[string]string)
+	err = visitStruct(reflect.ValueOf(options), nil, func(name string, help string, field *reflect.StructField, val reflect.Value, ptr interface{}) error {
+		if v := field.Tag.Get("env"); v != "" {
+			env[name] = v
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	properties := make(map[string]interface{}, len(flags))
+	for _, v := range flags {
+		prop := map[string]interface{}{}
+		typ := schemaType(v.Type)
+		prop["type"] = typ
+		switch v.Type {
+		case "uint", "uint8", "uint16", "uint32", "uint64":
+			prop["minimum"] = 0
+		case "url":
+			prop["format"] = "uri"
+		case "map":
+			prop["additionalProperties"] = map[string]interface{}{"type": "string"}
+		}
+		if v.Help != "" {
+			prop["description"] = v.Help
+		}
+		if v.hasDefault() && typ != "object" {
+			prop["default"] = schemaValue(v.Default, typ)
+		}
+		if len(v.Choices) > 0 {
+			prop["enum"] = v.Choices
+		}
+		if e := env[v.Name]; e != "" {
+			prop["env"] = e
+		}
+		properties[v.Name] = prop
+	}
+	return map[string]interface{}{
+		"$schema":    "http://json-schema.org/draft-07/schema#",
+		"type":       "object",
+		"properties": properties,
+	}, nil
+}
+
+// schemaType returns the JSON Schema type for the
+// given Flag.Type.
+func schemaType(typ string) string {
+	switch {
+	case typ == "bool":
+		return "boolean"
+	case strings.HasPrefix(typ, "int"), strings.HasPrefix(typ, "uint"):
+		return "integer"
+	case strings.HasPrefix(typ, "float"):
+		return "number"
+	case typ == "map":
+		return "object"
+	}
+	return "string"
+}
+
+// schemaValue converts the given default value to
+// the Go type corresponding to the JSON Schema type.
+func schemaValue(value string, typ string) interface{} {
+	switch typ {
+	case "boolean":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	case "integer":
+		if n, err := strconv.ParseInt(value, 0, 64); err == nil {
+			return n
+		}
+		if n, err := strconv.ParseUint(value, 0, 64); err == nil {
+			return n
+		}
+	case "number":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return value
+}
@@ -0,0 +1,49 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// SignalError represents the termination of a command
+// due to a signal. When passed to Exit, the exit status
+// is 128 plus the signal number, following the usual
+// shell conventions.
+type SignalError struct {
+	Signal os.Signal
+}
+
+func (e *SignalError) Error() string {
+	return fmt.Sprintf("interrupted by signal %s", e.Signal)
+}
+
+func (e *SignalError) status() int {
+	if sig, ok := e.Signal.(syscall.Signal); ok {
+		return 128 + int(sig)
+	}
+	return 1
+}
+
+// handleSignals calls fn when the process receives either
+// SIGINT or SIGTERM and then exits with the status corresponding
+// to the signal. The returned function must be called to stop
+// handling the signals.
+func handleSignals(fn func(os.Signal)) func() {
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-ch:
+			fn(sig)
+			Exit(&SignalError{Signal: sig})
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
@@ -2,8 +2,12 @@ package command
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
 	"unicode"
 	"unicode/utf8"
 )
@@ -11,16 +15,39 @@ import (
 var (
 	errNoPointer = errors.New("not a pointer")
 	errNoStruct  = errors.New("not a struct")
+
+	flagValueType = reflect.TypeOf((*flag.Value)(nil)).Elem()
 )
 
-func defaultFieldName(name string) string {
+// KebabCase converts a field name into a flag name by lowercasing
+// it and separating its words with dashes (e.g. MyField becomes
+// my-field). It's the default naming convention for flags without
+// a name tag. See also Options.FieldName.
+func KebabCase(name string) string {
+	return joinFieldName(name, "-")
+}
+
+// SnakeCase converts a field name into a flag name by lowercasing
+// it and separating its words with underscores (e.g. MyField becomes
+// my_field).
+func SnakeCase(name string) string {
+	return joinFieldName(name, "_")
+}
+
+// LowerCase converts a field name into a flag name by lowercasing
+// it (e.g. MyField becomes myfield).
+func LowerCase(name string) string {
+	return strings.ToLower(name)
+}
+
+func joinFieldName(name string, sep string) string {
 	var runes []rune
 	for ii := 0; ii < len(name); {
 		c, s := utf8.DecodeRuneInString(name[ii:])
 		ii += s
 		if unicode.IsUpper(c) {
 			if len(runes) > 0 {
-				runes = append(runes, '-')
+				runes = append(runes, []rune(sep)...)
 			}
 			c = unicode.ToLower(c)
 		}
@@ -29,22 +56,130 @@ func defaultFieldName(name string) string {
 	return string(runes)
 }
 
+// fieldChoices returns the valid choices for the given field,
+// as specified by its choices tag. If the field has no choices
+// tag, it returns nil.
+func fieldChoices(field *reflect.StructField) []string {
+	tag := field.Tag.Get("choices")
+	if tag == "" {
+		return nil
+	}
+	choices := strings.Split(tag, ",")
+	for ii, v := range choices {
+		choices[ii] = strings.TrimSpace(v)
+	}
+	return choices
+}
+
+// fieldHidden returns true iff the given field has been marked
+// as hidden using its hidden tag.
+func fieldHidden(field *reflect.StructField) bool {
+	hidden, _ := strconv.ParseBool(field.Tag.Get("hidden"))
+	return hidden
+}
+
+// fieldLayout returns the time layout for the given field, as
+// specified by its layout tag, defaulting to time.RFC3339.
+func fieldLayout(field *reflect.StructField) string {
+	if layout := field.Tag.Get("layout"); layout != "" {
+		return layout
+	}
+	return time.RFC3339
+}
+
 type structVisitor func(name string, help string, field *reflect.StructField, val reflect.Value, ptr interface{}) error
 
-func visitStruct(val reflect.Value, visitor structVisitor) error {
-	if val.Kind() != reflect.Ptr {
-		return errNoPointer
+// visitStruct calls visitor for every field in the struct pointed by
+// val, using fieldName to determine the names of the fields without
+// a name tag. If fieldName is nil, KebabCase is used.
+func visitStruct(val reflect.Value, fieldName func(string) string, visitor structVisitor) error {
+	return visitStructs([]reflect.Value{val}, fieldName, visitor)
+}
+
+// visitStructs works like visitStruct, but it visits all the structs
+// pointed by vals in order, as if their fields were declared in a
+// single struct, so flag names must be unique across all of them.
+func visitStructs(vals []reflect.Value, fieldName func(string) string, visitor structVisitor) error {
+	if fieldName == nil {
+		fieldName = KebabCase
 	}
-	val = reflect.Indirect(val)
-	if val.Kind() != reflect.Struct {
-		return errNoStruct
+	v := &structVisit{
+		visitor:   visitor,
+		fieldName: fieldName,
+		names:     make(map[string]string),
+		types:     make(map[reflect.Type]bool),
+		qualify:   len(vals) > 1,
 	}
+	for _, val := range vals {
+		if val.Kind() != reflect.Ptr {
+			return errNoPointer
+		}
+		val = reflect.Indirect(val)
+		if val.Kind() != reflect.Struct {
+			return errNoStruct
+		}
+		if err := v.visit(val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// structVisit holds the state while visiting a struct and
+// the ones embedded into it.
+type structVisit struct {
+	visitor   structVisitor
+	fieldName func(string) string
+	// names maps flag names to their field names
+	names map[string]string
+	// types contains the struct types being visited
+	types map[reflect.Type]bool
+	// qualify indicates that field names should be qualified
+	// with their type, since several structs are visited
+	qualify bool
+}
+
+// isEmbeddedStruct returns true iff the given field is an
+// embedded struct (or pointer to struct) which should have
+// its fields flattened into the parent struct.
+func isEmbeddedStruct(field *reflect.StructField) bool {
+	if !field.Anonymous {
+		return false
+	}
+	typ := field.Type
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct || typ == timeType {
+		return false
+	}
+	return !reflect.PtrTo(typ).Implements(flagValueType)
+}
+
+func (v *structVisit) visit(val reflect.Value) error {
 	typ := val.Type()
+	if v.types[typ] {
+		return fmt.Errorf("type %s embeds itself", typ)
+	}
+	v.types[typ] = true
+	defer delete(v.types, typ)
 	for ii := 0; ii < typ.NumField(); ii++ {
 		field := typ.Field(ii)
 		fieldVal := val.Field(ii)
+		if isEmbeddedStruct(&field) {
+			if fieldVal.Kind() == reflect.Ptr {
+				if fieldVal.IsNil() {
+					fieldVal.Set(reflect.New(field.Type.Elem()))
+				}
+				fieldVal = fieldVal.Elem()
+			}
+			if err := v.visit(fieldVal); err != nil {
+				return err
+			}
+			continue
+		}
 		ptr := fieldVal.Addr().Interface()
-		name := defaultFieldName(field.Name)
+		name := v.fieldName(field.Name)
 		var help string
 		if n := field.Tag.Get("name"); n != "" {
 			name = n
@@ -55,7 +190,15 @@ func visitStruct(val reflect.Value, visitor structVisitor) error {
 		if name == "" {
 			return fmt.Errorf("no name provided for field %s in type %s", field.Name, typ)
 		}
-		if err := visitor(name, help, &field, fieldVal, ptr); err != nil {
+		fieldName := field.Name
+		if v.qualify {
+			fieldName = typ.String() + "." + field.Name
+		}
+		if prev, found := v.names[name]; found {
+			return fmt.Errorf("duplicate flag name %q in fields %s and %s", name, prev, fieldName)
+		}
+		v.names[name] = fieldName
+		if err := v.visitor(name, help, &field, fieldVal, ptr); err != nil {
 			return err
 		}
 	}
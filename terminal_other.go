@@ -0,0 +1,10 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package command
+
+// fdWidth always returns 0, since the terminal width can't
+// be queried on this platform.
+func fdWidth(fd uintptr) int {
+	return 0
+}
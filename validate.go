@@ -0,0 +1,85 @@
+package command
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ValidationError is returned from Validate and contains
+// all the problems found in the commands and options.
+type ValidationError struct {
+	Errors []error
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for ii, v := range e.Errors {
+		msgs[ii] = v.Error()
+	}
+	return fmt.Sprintf("%d problems found:\n%s", len(e.Errors), strings.Join(msgs, "\n"))
+}
+
+// Validate checks that the global options in opts (which might be nil)
+// and the options for every command can be represented as flags, that
+// no command declares a required argument after an optional one, as
+// well as that no command is shadowed by the automatic help command. If
+// any problems are found, a *ValidationError with all of them is returned.
+//
+// Problems in commands and options are usually detected only when the
+// command is run, so calling Validate from a test allows catching them
+// early.
+func Validate(commands []*Cmd, opts *Options) error {
+	var errs []error
+	if opts != nil && opts.Options != nil {
+		errs = append(errs, validateOptions(opts, "", opts.Options)...)
+	}
+	for _, v := range commands {
+		if opts.isHelpCommand(v.Name) {
+			errs = append(errs, fmt.Errorf("command %s: shadowed by the automatic help command", v.Name))
+		}
+		if err := v.checkArgs(); err != nil {
+			errs = append(errs, fmt.Errorf("command %s: %v", v.Name, err))
+		}
+		if options := v.options(); len(options) > 0 {
+			errs = append(errs, validateOptions(opts, v.Name, options...)...)
+		}
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+func validateOptions(opts *Options, name string, options ...interface{}) []error {
+	prefix := "global options"
+	if name != "" {
+		prefix = fmt.Sprintf("command %s", name)
+	}
+	var errs []error
+	svals := make([]reflect.Value, len(options))
+	for ii, v := range options {
+		val := reflect.ValueOf(v)
+		if val.Kind() == reflect.Ptr && !val.IsNil() {
+			// Work on a copy, since setting up the flags
+			// might initialize some fields.
+			cpy := reflect.New(val.Type().Elem())
+			cpy.Elem().Set(val.Elem())
+			val = cpy
+		}
+		svals[ii] = val
+	}
+	_, err := setupFlags(opts, name, func(err error) error {
+		errs = append(errs, fmt.Errorf("%s: %v", prefix, err))
+		return nil
+	}, svals...)
+	if err != nil {
+		if _, ok := err.(*InvalidOptionsError); ok {
+			// Already includes the command name
+			errs = append(errs, err)
+		} else {
+			errs = append(errs, fmt.Errorf("%s: %v", prefix, err))
+		}
+	}
+	return errs
+}
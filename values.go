@@ -0,0 +1,337 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	urlType  = reflect.TypeOf((*url.URL)(nil))
+)
+
+func isBasicKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Bool, reflect.Float64, reflect.Int, reflect.Uint, reflect.Int64, reflect.Uint64, reflect.String:
+		return true
+	}
+	return isFixedIntKind(k)
+}
+
+// isFixedIntKind returns true for the integer kinds not
+// directly supported by the flag package.
+func isFixedIntKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return true
+	}
+	return false
+}
+
+func numError(err error) error {
+	if ne, ok := err.(*strconv.NumError); ok {
+		return ne.Err
+	}
+	return err
+}
+
+// intError returns the error for an integer which couldn't be
+// parsed as typ, including the valid range when it overflows.
+func intError(err error, typ reflect.Type) error {
+	err = numError(err)
+	if err != strconv.ErrRange {
+		return err
+	}
+	bits := uint(typ.Bits())
+	switch typ.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fmt.Errorf("out of range for %s (0 to %d)", typ.Kind(), uint64(math.MaxUint64)>>(64-bits))
+	}
+	return fmt.Errorf("out of range for %s (%d to %d)", typ.Kind(), int64(-1)<<(bits-1), int64(math.MaxInt64)>>(64-bits))
+}
+
+// setValueString parses s according to the kind of val
+// and stores the result in val.
+func setValueString(val reflect.Value, s string) error {
+	switch val.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return numError(err)
+		}
+		val.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 0, val.Type().Bits())
+		if err != nil {
+			return intError(err, val.Type())
+		}
+		val.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 0, val.Type().Bits())
+		if err != nil {
+			return intError(err, val.Type())
+		}
+		val.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, val.Type().Bits())
+		if err != nil {
+			return numError(err)
+		}
+		val.SetFloat(f)
+	case reflect.String:
+		val.SetString(s)
+	default:
+		return fmt.Errorf("can't parse values of type %s", val.Type())
+	}
+	return nil
+}
+
+func isStringMap(typ reflect.Type) bool {
+	return typ.Kind() == reflect.Map && typ.Key().Kind() == reflect.String && typ.Elem().Kind() == reflect.String
+}
+
+func formatMap(val reflect.Value) string {
+	if !val.IsValid() || val.Len() == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, val.Len())
+	for _, k := range val.MapKeys() {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k.String(), val.MapIndex(k).String()))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// mapValue implements flag.Value for map[string]string
+// fields. Every time the flag is specified, a new key=value
+// pair is added to the map.
+type mapValue struct {
+	val reflect.Value
+}
+
+func (m *mapValue) String() string {
+	return formatMap(m.val)
+}
+
+func (m *mapValue) Set(s string) error {
+	p := strings.IndexByte(s, '=')
+	if p < 0 {
+		return fmt.Errorf("missing '=' in %q, must be in the form key=value", s)
+	}
+	typ := m.val.Type()
+	key := reflect.ValueOf(s[:p]).Convert(typ.Key())
+	value := reflect.ValueOf(s[p+1:]).Convert(typ.Elem())
+	m.val.SetMapIndex(key, value)
+	return nil
+}
+
+// choiceValue implements flag.Value for string fields
+// which only accept a fixed set of values.
+type choiceValue struct {
+	val     reflect.Value
+	choices []string
+}
+
+func (c *choiceValue) String() string {
+	if !c.val.IsValid() {
+		return ""
+	}
+	return c.val.String()
+}
+
+func (c *choiceValue) isValid(s string) bool {
+	for _, v := range c.choices {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *choiceValue) Set(s string) error {
+	if !c.isValid(s) {
+		return fmt.Errorf("must be one of %s", strings.Join(c.choices, ", "))
+	}
+	c.val.SetString(s)
+	return nil
+}
+
+// pointerValue implements flag.Value for pointers to
+// basic types. The pointer is only allocated when the
+// flag is set, so a nil pointer indicates that the flag
+// was not provided.
+type pointerValue struct {
+	val reflect.Value
+}
+
+func (p *pointerValue) String() string {
+	if !p.val.IsValid() || p.val.IsNil() {
+		return ""
+	}
+	return fmt.Sprint(p.val.Elem().Interface())
+}
+
+func (p *pointerValue) Set(s string) error {
+	elem := reflect.New(p.val.Type().Elem())
+	if err := setValueString(elem.Elem(), s); err != nil {
+		return err
+	}
+	p.val.Set(elem)
+	return nil
+}
+
+func (p *pointerValue) IsBoolFlag() bool {
+	return p.val.IsValid() && p.val.Type().Elem().Kind() == reflect.Bool
+}
+
+// negatedBoolValue implements flag.Value for the automatically
+// generated -no-<name> flags, setting the underlying bool to
+// the opposite of the provided value.
+type negatedBoolValue struct {
+	val reflect.Value
+}
+
+func (n *negatedBoolValue) String() string {
+	if !n.val.IsValid() {
+		return "false"
+	}
+	return strconv.FormatBool(!n.val.Bool())
+}
+
+func (n *negatedBoolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return numError(err)
+	}
+	n.val.SetBool(!b)
+	return nil
+}
+
+func (n *negatedBoolValue) IsBoolFlag() bool {
+	return true
+}
+
+// timeValue implements flag.Value for time.Time
+// fields, parsing them with the given layout.
+type timeValue struct {
+	val    reflect.Value
+	layout string
+}
+
+func (t *timeValue) String() string {
+	if !t.val.IsValid() {
+		return ""
+	}
+	return formatTime(t.val, t.layout)
+}
+
+func (t *timeValue) Set(s string) error {
+	tt, err := time.Parse(t.layout, s)
+	if err != nil {
+		return fmt.Errorf("can't parse %q as a time, expecting layout %s", s, t.layout)
+	}
+	t.val.Set(reflect.ValueOf(tt))
+	return nil
+}
+
+func formatTime(val reflect.Value, layout string) string {
+	t := val.Interface().(time.Time)
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(layout)
+}
+
+// intValue implements flag.Value for integer fields,
+// checking that the values fit in the field type and
+// reporting the valid range otherwise.
+type intValue struct {
+	val reflect.Value
+}
+
+func (i *intValue) String() string {
+	if !i.val.IsValid() {
+		return "0"
+	}
+	return fmt.Sprint(i.val.Interface())
+}
+
+func (i *intValue) Set(s string) error {
+	return setValueString(i.val, s)
+}
+
+// fileRefValue implements flag.Value for string fields when
+// Options.FileRefs is enabled, reading values starting with @
+// from the file named by the rest of the value.
+type fileRefValue struct {
+	val     reflect.Value
+	choices *choiceValue
+}
+
+func (f *fileRefValue) String() string {
+	if !f.val.IsValid() {
+		return ""
+	}
+	return f.val.String()
+}
+
+func (f *fileRefValue) Set(s string) error {
+	switch {
+	case strings.HasPrefix(s, "@@"):
+		s = s[1:]
+	case strings.HasPrefix(s, "@"):
+		data, err := ioutil.ReadFile(s[1:])
+		if err != nil {
+			return err
+		}
+		s = strings.TrimSpace(string(data))
+	}
+	if f.choices != nil {
+		return f.choices.Set(s)
+	}
+	f.val.SetString(s)
+	return nil
+}
+
+// urlValue implements flag.Value for *url.URL fields,
+// only accepting absolute URLs with a host.
+type urlValue struct {
+	val reflect.Value
+}
+
+func (u *urlValue) String() string {
+	return formatURL(u.val)
+}
+
+func (u *urlValue) Set(s string) error {
+	parsed, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	var missing []string
+	if parsed.Scheme == "" {
+		missing = append(missing, "scheme")
+	}
+	if parsed.Host == "" {
+		missing = append(missing, "host")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("invalid URL %q: missing %s", s, strings.Join(missing, " and "))
+	}
+	u.val.Set(reflect.ValueOf(parsed))
+	return nil
+}
+
+func formatURL(val reflect.Value) string {
+	if !val.IsValid() || val.IsNil() {
+		return ""
+	}
+	return val.Interface().(*url.URL).String()
+}
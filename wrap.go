@@ -0,0 +1,74 @@
+package command
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultHelpWidth = 80
+	// minWrapWidth is the minimum width available for wrapping
+	// text. If there's less space available, text is not wrapped.
+	minWrapWidth = 20
+	// flagHelpIndent is the width of the "    \t" prefix used for
+	// flag help lines, assuming 8 column tab stops.
+	flagHelpIndent = 8
+)
+
+// terminalWidth returns the width of the terminal as indicated by
+// the COLUMNS environment variable. Since most shells don't export
+// it, the terminal which os.Stderr or os.Stdout refer to is queried
+// when it's not set or it has an invalid value, falling back to 80
+// when neither of them is a terminal.
+func terminalWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	for _, f := range []*os.File{os.Stderr, os.Stdout} {
+		if n := fdWidth(f.Fd()); n > 0 {
+			return n
+		}
+	}
+	return defaultHelpWidth
+}
+
+// wrapText wraps every line in s to the given width, breaking only
+// at whitespace, so words longer than width are never split. The
+// indentation of each line is preserved on its continuation lines.
+// Widths are measured in runes rather than bytes. If width is too
+// small, s is returned unchanged.
+func wrapText(s string, width int) string {
+	if width < minWrapWidth {
+		return s
+	}
+	lines := strings.Split(s, "\n")
+	wrapped := make([]string, 0, len(lines))
+	for _, line := range lines {
+		rest := strings.TrimLeftFunc(line, unicode.IsSpace)
+		lead := line[:len(line)-len(rest)]
+		words := strings.Fields(rest)
+		if len(words) == 0 {
+			wrapped = append(wrapped, line)
+			continue
+		}
+		cur := lead + words[0]
+		curWidth := utf8.RuneCountInString(cur)
+		leadWidth := utf8.RuneCountInString(lead)
+		for _, w := range words[1:] {
+			wordWidth := utf8.RuneCountInString(w)
+			if curWidth+1+wordWidth > width {
+				wrapped = append(wrapped, cur)
+				cur = lead + w
+				curWidth = leadWidth + wordWidth
+				continue
+			}
+			cur += " " + w
+			curWidth += 1 + wordWidth
+		}
+		wrapped = append(wrapped, cur)
+	}
+	return strings.Join(wrapped, "\n")
+}